@@ -0,0 +1,199 @@
+package nodepacker
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maximum nesting of aliases, the statement of an alias can use other aliases
+const maxAliasDepth = 10
+
+var (
+	aliasName  = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+	aliasParam = regexp.MustCompile(`\$([1-9][0-9]*|@)`)
+)
+
+// aliasParams returns the highest positional parameter $n of an alias statement and whether it
+// uses all arguments with $@
+func aliasParams(statement string) (int, bool) {
+	n, all := 0, false
+	for _, m := range aliasParam.FindAllStringSubmatch(statement, -1) {
+		if m[1] == "@" {
+			all = true
+			continue
+		}
+		i, _ := strconv.Atoi(m[1])
+		if i > n {
+			n = i
+		}
+	}
+	return n, all
+}
+
+// expandAlias substitutes the arguments for the parameters $1, $2, ... and $@ of an alias
+// statement. The arguments of an alias without parameters are appended to the statement.
+// Arguments that were quoted, see quotedArgs, stay quoted.
+func expandAlias(statement string, args []string, argsQuoted []bool) (string, error) {
+	quoted := quoteWords(args, argsQuoted)
+
+	n, all := aliasParams(statement)
+	if n == 0 && !all {
+		return strings.Join(append([]string{statement}, quoted...), " "), nil
+	}
+	if len(args) < n || (!all && len(args) > n) {
+		return "", argsErrorf("expected %d arguments, got %d", n, len(args))
+	}
+
+	return aliasParam.ReplaceAllStringFunc(statement, func(p string) string {
+		if p == "$@" {
+			return strings.Join(quoted, " ")
+		}
+		i, _ := strconv.Atoi(p[1:])
+		return quoted[i-1]
+	}), nil
+}
+
+// aliasUsage is the synopsis of the arguments of an alias
+func aliasUsage(statement string) string {
+	n, all := aliasParams(statement)
+	if n == 0 {
+		return "[args...]"
+	}
+	var ps []string
+	for i := 1; i <= n; i++ {
+		ps = append(ps, fmt.Sprintf("<arg%d>", i))
+	}
+	if all {
+		ps = append(ps, "[args...]")
+	}
+	return strings.Join(ps, " ")
+}
+
+// validAlias checks that an alias doesn't replace a command and that its statement can be split
+func validAlias(cctx *CommandContext, name, statement string) error {
+	if !aliasName.MatchString(name) {
+		return argsErrorf("invalid alias name %s, expected a letter followed by letters, digits, _ or -", name)
+	}
+	if _, ok := cctx.aliases[name]; !ok {
+		if _, ok := cctx.specs[name]; ok {
+			return argsErrorf("%s is a command", name)
+		}
+	}
+	sts, err := splitStatements(statement)
+	if err != nil {
+		return err
+	}
+	if len(sts) == 0 {
+		return argsErrorf("expected a statement for alias %s", name)
+	}
+	return nil
+}
+
+// runAlias returns the command executing an alias. The statement is looked up when the alias runs,
+// so redefining the alias changes the command.
+func runAlias(name string) CommandFn {
+	return func(cctx *CommandContext, args []string) error {
+		if cctx.aliasDepth >= maxAliasDepth {
+			return fmt.Errorf("aliases nested more than %d levels deep", maxAliasDepth)
+		}
+		statement, err := expandAlias(cctx.aliases[name], args, cctx.quotedArgs(args))
+		if err != nil {
+			return err
+		}
+
+		cctx.aliasDepth++
+		defer func() { cctx.aliasDepth-- }()
+		return cctx.execute(statement)
+	}
+}
+
+// setAlias registers an alias as a command, so that it is completed and listed by help like the
+// other commands. An empty statement removes the alias.
+func (crh *CommandReplHandler) setAlias(name, statement string) {
+	crh.hb.remove(name)
+	delete(crh.cctx.aliases, name)
+	if statement != "" {
+		crh.cctx.aliases[name] = statement
+		cs := crh.hb.add(runAlias(name), name, "alias for "+statement).
+			usage(aliasUsage(statement))
+		cs.alias = statement
+	}
+	crh.cc = crh.hb.completer()
+}
+
+// saveAliases saves the aliases in the config file, leaving its other settings as they are
+func saveAliases(cctx *CommandContext) {
+	cfg, err := readConfig(cctx.configPath)
+	if err == nil {
+		cfg.Aliases = make(map[string]string)
+		for name, statement := range cctx.aliases {
+			cfg.Aliases[name] = statement
+		}
+		err = saveConfig(cctx.configPath, cfg)
+	}
+	if err != nil {
+		cctx.infoln("warning: failed to save the aliases in", cctx.configPath+":", err)
+	}
+}
+
+func sortedAliasNames(cctx *CommandContext) []string {
+	names := make([]string, 0, len(cctx.aliases))
+	for name := range cctx.aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// alias [name [statement...]] lists, shows or defines aliases
+func aliasCommand(cctx *CommandContext, args []string) error {
+	switch len(args) {
+	case 0:
+		for _, name := range sortedAliasNames(cctx) {
+			cctx.printf("alias %s %s\n", name, quoteWord(cctx.aliases[name]))
+		}
+		return nil
+	case 1:
+		statement, ok := cctx.aliases[args[0]]
+		if !ok {
+			return notFoundErrorf("unknown alias %s", args[0])
+		}
+		cctx.printf("alias %s %s\n", args[0], quoteWord(statement))
+		return nil
+	}
+
+	// a single argument is the statement as typed, several are the words of a statement
+	statement := args[1]
+	if len(args) > 2 {
+		statement = strings.Join(quoteWords(args[1:], cctx.quotedArgs(args[1:])), " ")
+	}
+
+	err := validAlias(cctx, args[0], statement)
+	if err != nil {
+		return err
+	}
+	cctx.setAlias(args[0], statement)
+	saveAliases(cctx)
+	return nil
+}
+
+// unalias <name>...
+func unaliasCommand(cctx *CommandContext, args []string) error {
+	if len(args) == 0 {
+		return argsErrorf("expected one or more aliases")
+	}
+	for _, name := range args {
+		if _, ok := cctx.aliases[name]; !ok {
+			return notFoundErrorf("unknown alias %s", name)
+		}
+	}
+
+	for _, name := range args {
+		cctx.setAlias(name, "")
+	}
+	saveAliases(cctx)
+	return nil
+}
@@ -0,0 +1,31 @@
+package nodepacker
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExpandAlias(t *testing.T) {
+	tests := []struct {
+		statement string
+		args      []string
+		quoted    []bool
+		want      string
+		err       error
+	}{
+		{"machines_show -sort price", []string{"cpu", ">=", "8"}, nil, "machines_show -sort price cpu >= 8", nil},
+		{"manifests_read $1; nodes_pack", []string{"my dir"}, nil, "manifests_read 'my dir'; nodes_pack", nil},
+		{"pods_pin $2 $1", []string{"node-1", "web-0"}, nil, "pods_pin web-0 node-1", nil},
+		{"nodes_pack -rank $1 $@", []string{"price", "-search"}, nil, "nodes_pack -rank price price -search", nil},
+		{"machines_show $@", []string{"name", "=", "cpu"}, []bool{false, false, true}, "machines_show name = 'cpu'", nil},
+		{"pods_pin $1 $2", []string{"web-0"}, nil, "", ErrInvalidArgs},
+		{"pods_pin $1", []string{"web-0", "node-1"}, nil, "", ErrInvalidArgs},
+	}
+
+	for _, test := range tests {
+		got, err := expandAlias(test.statement, test.args, test.quoted)
+		if !errors.Is(err, test.err) || got != test.want {
+			t.Errorf("%q %q: expected %q, %v, got %q, %v", test.statement, test.args, test.want, test.err, got, err)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package nodepacker
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"nodepacker/types"
+	"gopkg.in/yaml.v3"
+)
+
+// built-in catalogs of the providers, used when no machines have been fetched or imported
+var (
+	//go:embed catalog/gce.yaml
+	gceCatalog []byte
+	//go:embed catalog/aws.yaml
+	awsCatalog []byte
+	//go:embed catalog/azure.yaml
+	azureCatalog []byte
+)
+
+// machineCatalog lists machine types, the zones they are available in and optionally their
+// prices, for example
+//
+//	version: "2020.10"
+//	zones:
+//	  us-central1-a: [n1, n2, e2]
+//	machineTypes:
+//	  - {name: n1-standard-4, cpuMillis: 4000, memoryMB: 15000}
+//	prices:
+//	  disk: 0.04
+//	  machines:
+//	    n1-standard-4: {onDemand: 0.19, spot: 0.04, commit1y: 0.12, commit3y: 0.085}
+//
+// A machine type is available in a zone if its family is listed for the zone. The family is the
+// part of the name before the first - or . unless it is given with family. The prices apply to
+// every zone of the catalog.
+type machineCatalog struct {
+	Version      string              `json:"version" yaml:"version"`
+	Zones        map[string][]string `json:"zones" yaml:"zones"`
+	MachineTypes []catalogMachine    `json:"machineTypes" yaml:"machineTypes"`
+	Prices       types.ZonePrices    `json:"prices" yaml:"prices,omitempty"`
+}
+
+// catalogMachine is a machine type of a catalog, the attributes of types.MachineInfo are optional
+type catalogMachine struct {
+	Name              string `json:"name" yaml:"name"`
+	CPU               int64  `json:"cpuMillis" yaml:"cpuMillis"`
+	Memory            int64  `json:"memoryMB" yaml:"memoryMB"`
+	types.MachineInfo `yaml:",inline"`
+}
+
+func (cm catalogMachine) family() string {
+	if cm.Family != "" {
+		return cm.Family
+	}
+	if i := strings.IndexAny(cm.Name, "-."); i >= 0 {
+		return cm.Name[:i]
+	}
+	return cm.Name
+}
+
+// machines returns the machine types of the catalog by zone
+func (mc *machineCatalog) machines() (types.Machines, error) {
+	if len(mc.Zones) == 0 {
+		return nil, fmt.Errorf("catalog has no zones")
+	}
+
+	byFamily := make(map[string][]catalogMachine)
+	for _, cm := range mc.MachineTypes {
+		if cm.Name == "" || cm.CPU <= 0 || cm.Memory <= 0 {
+			return nil, fmt.Errorf("invalid machine type %+v, expected name, cpuMillis and memoryMB", cm)
+		}
+		family := cm.family()
+		byFamily[family] = append(byFamily[family], cm)
+	}
+
+	machines := make(types.Machines)
+	for zone, families := range mc.Zones {
+		ms := make(map[string]types.Resource)
+		for _, family := range families {
+			for _, cm := range byFamily[family] {
+				ms[cm.Name] = types.Resource{Name: cm.Name, CPU: cm.CPU, Memory: cm.Memory, Machine: cm.MachineInfo}
+			}
+		}
+		machines[zone] = ms
+	}
+	return machines, nil
+}
+
+// prices returns the prices of the catalog by zone, nil if it has none
+func (mc *machineCatalog) prices() types.Prices {
+	if len(mc.Prices.Machines) == 0 && mc.Prices.Disk == 0 {
+		return nil
+	}
+	prices := make(types.Prices)
+	for zone := range mc.Zones {
+		prices.Merge(types.Prices{zone: mc.Prices})
+	}
+	return prices
+}
+
+// machinesFromRecords returns the machine types of machine records by zone
+func machinesFromRecords(records []machineRecord) (types.Machines, error) {
+	machines := make(types.Machines)
+	for _, r := range records {
+		if r.Name == "" || r.Zone == "" || r.CPU <= 0 || r.Memory <= 0 {
+			return nil, fmt.Errorf("invalid machine %+v, expected name, zone, cpuMillis and memoryMB", r)
+		}
+		if machines[r.Zone] == nil {
+			machines[r.Zone] = make(map[string]types.Resource)
+		}
+		machines[r.Zone][r.Name] = types.Resource{
+			Name:   r.Name,
+			CPU:    r.CPU,
+			Memory: r.Memory,
+			Machine: types.MachineInfo{
+				Family:             r.Family,
+				Generation:         r.Generation,
+				Arch:               r.Arch,
+				SharedCPU:          r.SharedCPU,
+				LocalSSD:           r.LocalSSD,
+				MaxPersistentDisks: r.MaxPersistentDisks,
+				NetworkMbps:        r.NetworkMbps,
+				Deprecated:         r.Deprecated,
+			},
+		}
+	}
+	return machines, nil
+}
+
+// readMachineCatalog reads machine types from a file. YAML and JSON files hold a catalog, see
+// machineCatalog, or a list of machine records as machines_show -o yaml|json writes them. CSV files
+// have a header naming the columns name, zone, cpuMillis and memoryMB, like machines_show -o csv.
+func readMachineCatalog(path string) (types.Machines, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var machines types.Machines
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		machines, err = decodeMachineCSV(f)
+	} else {
+		machines, err = decodeMachineYAML(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read machines from %s: %v", path, err)
+	}
+	return machines, nil
+}
+
+// decodeMachineYAML decodes a catalog or a list of machine records, JSON is decoded as YAML
+func decodeMachineYAML(r io.Reader) (types.Machines, error) {
+	var doc yaml.Node
+	err := yaml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("empty document")
+	}
+
+	if doc.Content[0].Kind == yaml.SequenceNode {
+		var records []machineRecord
+		err := doc.Decode(&records)
+		if err != nil {
+			return nil, err
+		}
+		return machinesFromRecords(records)
+	}
+
+	var mc machineCatalog
+	err = doc.Decode(&mc)
+	if err != nil {
+		return nil, err
+	}
+	return mc.machines()
+}
+
+// machineCSVAttributes are the optional columns of machine CSV files
+var machineCSVAttributes = []struct {
+	column string
+	set    func(r *machineRecord, value string) error
+}{
+	{"family", func(r *machineRecord, v string) error { r.Family = v; return nil }},
+	{"generation", func(r *machineRecord, v string) (err error) { r.Generation, err = strconv.Atoi(v); return }},
+	{"arch", func(r *machineRecord, v string) error { r.Arch = v; return nil }},
+	{"sharedCpu", func(r *machineRecord, v string) (err error) { r.SharedCPU, err = strconv.ParseBool(v); return }},
+	{"localSsd", func(r *machineRecord, v string) (err error) { r.LocalSSD, err = strconv.ParseBool(v); return }},
+	{"maxPersistentDisks", func(r *machineRecord, v string) (err error) { r.MaxPersistentDisks, err = strconv.Atoi(v); return }},
+	{"networkMbps", func(r *machineRecord, v string) (err error) { r.NetworkMbps, err = strconv.ParseInt(v, 10, 64); return }},
+	{"deprecated", func(r *machineRecord, v string) error { r.Deprecated = v; return nil }},
+}
+
+func decodeMachineCSV(r io.Reader) (types.Machines, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("empty file")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"name", "zone", "cpuMillis", "memoryMB"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing column %s", name)
+		}
+	}
+
+	records := make([]machineRecord, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		cpu, err := strconv.ParseInt(row[columns["cpuMillis"]], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid cpuMillis: %v", i+2, err)
+		}
+		mem, err := strconv.ParseInt(row[columns["memoryMB"]], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid memoryMB: %v", i+2, err)
+		}
+		r := machineRecord{
+			Name:   row[columns["name"]],
+			Zone:   row[columns["zone"]],
+			CPU:    cpu,
+			Memory: mem,
+		}
+		for _, a := range machineCSVAttributes {
+			if c, ok := columns[a.column]; ok && row[c] != "" {
+				err := a.set(&r, row[c])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid %s: %v", i+2, a.column, err)
+				}
+			}
+		}
+		records = append(records, r)
+	}
+	return machinesFromRecords(records)
+}
+
+// machines_import <file>... adds the machine types of catalog files to the known machines
+func importMachinesCommand(cctx *CommandContext, args []string) error {
+	if len(args) == 0 {
+		return argsErrorf("expected one or more machine catalog files")
+	}
+
+	machines := make(types.Machines)
+	for zone, ms := range cctx.machines {
+		machines[zone] = make(map[string]types.Resource, len(ms))
+		for name, m := range ms {
+			machines[zone][name] = m
+		}
+	}
+
+	imported := 0
+	for _, path := range args {
+		ms, err := readMachineCatalog(path)
+		if err != nil {
+			return err
+		}
+		describeMachines(cctx.provider, ms)
+		for zone, zms := range ms {
+			if machines[zone] == nil {
+				machines[zone] = make(map[string]types.Resource, len(zms))
+			}
+			for name, m := range zms {
+				machines[zone][name] = m
+				imported++
+			}
+		}
+	}
+
+	cctx.machines = machines
+	cctx.infof("imported %d machine types\n", imported)
+	err := saveMachines(cctx.config.CacheDir, cctx.provider.Name(), machines)
+	if err != nil {
+		cctx.infoln("warning: failed to save machines in", cctx.config.CacheDir+":", err)
+	}
+	return nil
+}
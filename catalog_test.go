@@ -0,0 +1,110 @@
+package nodepacker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nodepacker/types"
+)
+
+func TestBuiltinMachines(t *testing.T) {
+	p, err := lookupProvider("gcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, version, err := p.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version == "" {
+		t.Error("expected a catalog version")
+	}
+
+	// without cached machines the built-in catalog is used
+	machines, warning, err := loadProviderMachines(t.TempDir(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(warning, "using the built-in catalog "+version) {
+		t.Errorf("expected a warning about the built-in catalog, got %q", warning)
+	}
+	m, ok := machines[p.DefaultZone()]["n1-standard-4"]
+	if !ok {
+		t.Fatalf("expected n1-standard-4 in %s", p.DefaultZone())
+	}
+	if m.CPU != 4000 || m.Memory != 15000 {
+		t.Errorf("unexpected n1-standard-4 %+v", m)
+	}
+}
+
+func TestImportMachines(t *testing.T) {
+	const zone = "us-central1-a"
+	crh := newTestHandler(t)
+	var out bytes.Buffer
+	crh.SetOutput(&out, &out)
+	crh.cctx.machines = types.Machines{
+		zone: {"n1-standard-4": {Name: "n1-standard-4", CPU: 4000, Memory: 15000}},
+	}
+
+	dir := t.TempDir()
+	for _, format := range []string{"csv", "yaml", "json"} {
+		out.Reset()
+		err := crh.Execute("machines_show -o " + format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "machines."+format)
+		err = ioutil.WriteFile(path, out.Bytes(), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ms, err := readMachineCatalog(path)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if m := ms[zone]["n1-standard-4"]; m.CPU != 4000 || m.Memory != 15000 {
+			t.Errorf("%s: unexpected machines %v", format, ms)
+		}
+	}
+
+	catalog := filepath.Join(dir, "catalog.yaml")
+	err := ioutil.WriteFile(catalog, []byte(`version: test
+zones:
+  europe-west4-a: [e2]
+machineTypes:
+  - {name: e2-standard-2, cpuMillis: 2000, memoryMB: 8000}
+  - {name: n2-standard-2, cpuMillis: 2000, memoryMB: 8000}
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = crh.Execute("machines_import " + catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := crh.cctx.machines
+	if _, ok := ms["europe-west4-a"]["e2-standard-2"]; !ok {
+		t.Errorf("expected e2-standard-2 in europe-west4-a, got %v", ms)
+	}
+	if _, ok := ms["europe-west4-a"]["n2-standard-2"]; ok {
+		t.Error("expected n2-standard-2 to be unavailable in europe-west4-a")
+	}
+	if _, ok := ms[zone]["n1-standard-4"]; !ok {
+		t.Error("expected machines_import to keep the known machines")
+	}
+	if _, err := readMachines(crh.cctx.config.CacheDir, "gcp"); err != nil {
+		t.Errorf("expected the imported machines to be cached: %v", err)
+	}
+
+	err = crh.Execute("undo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := crh.cctx.machines["europe-west4-a"]; ok {
+		t.Error("expected undo to revert the import")
+	}
+}
@@ -0,0 +1,150 @@
+package nodepacker
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+// cliShortcuts are command line names of commands besides their REPL name
+var cliShortcuts = map[string]string{
+	"pack": "nodes_pack",
+}
+
+// stringsFlag is a flag that can be given several times
+type stringsFlag []string
+
+func (sf *stringsFlag) String() string {
+	return strings.Join(*sf, ",")
+}
+
+func (sf *stringsFlag) Set(s string) error {
+	*sf = append(*sf, s)
+	return nil
+}
+
+// setupOptions are the flags every subcommand has. They set up the state the command runs on by
+// running the commands they are named after before the subcommand.
+type setupOptions struct {
+	session   string
+	prices    stringsFlag
+	zone      string
+	manifests stringsFlag
+	output    string
+}
+
+func (o *setupOptions) define(fs *flag.FlagSet) {
+	fs.StringVar(&o.session, "session", "", "load a session file first, see session_load")
+	fs.Var(&o.prices, "prices", "load a price catalog file, can be given several times, see prices_load")
+	fs.StringVar(&o.zone, "zone", "", "zone to use instead of the current zone, see machines_zone")
+	fs.Var(&o.manifests, "manifests", "read manifests from a file or directory, can be given several times, see manifests_read")
+	fs.StringVar(&o.output, "output", "", "default output format: "+strings.Join(outputFormats, ", ")+", see output")
+}
+
+// statements returns the commands with arguments that set up the state, in the order to run them
+func (o *setupOptions) statements() [][]string {
+	var sts [][]string
+	if o.output != "" {
+		sts = append(sts, []string{"output", o.output})
+	}
+	if o.session != "" {
+		sts = append(sts, []string{"session_load", o.session})
+	}
+	if len(o.prices) > 0 {
+		sts = append(sts, append([]string{"prices_load"}, o.prices...))
+	}
+	if o.zone != "" {
+		sts = append(sts, []string{"machines_zone", o.zone})
+	}
+	if len(o.manifests) > 0 {
+		sts = append(sts, append([]string{"manifests_read"}, o.manifests...))
+	}
+	return sts
+}
+
+// lookupCommand finds the command named by the first command line arguments: the REPL name
+// (nodes_pack), the REPL name split in two (nodes pack) or a shortcut (pack). It returns the
+// command name and the remaining arguments.
+func (crh *CommandReplHandler) lookupCommand(args []string) (string, []string, bool) {
+	if _, ok := crh.commands[args[0]]; ok {
+		return args[0], args[1:], true
+	}
+	if name, ok := cliShortcuts[args[0]]; ok {
+		return name, args[1:], true
+	}
+	if len(args) > 1 {
+		name := args[0] + "_" + args[1]
+		if _, ok := crh.commands[name]; ok {
+			return name, args[2:], true
+		}
+	}
+	return "", args, false
+}
+
+// PrintCommands prints the commands that can be used as subcommands to the info output
+func (crh *CommandReplHandler) PrintCommands() {
+	w := tabwriter.NewWriter(crh.cctx.info, 0, 0, 3, ' ', 0)
+	for _, name := range crh.cc.sortedCommandNames {
+		fmt.Fprintf(w, "  %s\t%s\n", name, crh.cc.commandSuggestionsByName[name].Description)
+	}
+	w.Flush()
+}
+
+// RunCommand runs a command given on the command line: <command> [flags] [args...].
+// The flags are the setup flags and the flags of the command, the remaining arguments are
+// passed to the command. It returns flag.ErrHelp if help was requested.
+func (crh *CommandReplHandler) RunCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("no command given")
+	}
+	name, args, ok := crh.lookupCommand(args)
+	if !ok {
+		return fmt.Errorf("unknown command %s, nodepacker help lists the commands", args[0])
+	}
+
+	var so setupOptions
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(crh.cctx.info)
+	so.define(fs)
+
+	// the flags of the command are redefined on fs, the ones that are set are passed on
+	commandFlags := make(map[string]bool)
+	if fsf := crh.specs[name].flagSet; fsf != nil {
+		fsf().VisitAll(func(f *flag.Flag) {
+			fs.Var(f.Value, f.Name, f.Usage)
+			commandFlags[f.Name] = true
+		})
+	}
+
+	fs.Usage = func() {
+		out := fs.Output()
+		synopsis := strings.TrimPrefix(crh.specs[name].synopsis, "[flags]")
+		fmt.Fprintf(out, "usage: %s\n\n", strings.Join(strings.Fields("nodepacker "+name+" [flags] "+synopsis), " "))
+		fmt.Fprintf(out, "%s\n\nflags:\n", crh.cc.commandSuggestionsByName[name].Description)
+		fs.PrintDefaults()
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	var commandArgs []string
+	fs.Visit(func(f *flag.Flag) {
+		if commandFlags[f.Name] {
+			commandArgs = append(commandArgs, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	commandArgs = append(commandArgs, fs.Args()...)
+
+	for _, st := range so.statements() {
+		err := crh.run(st[0], st[1:], nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return crh.run(name, commandArgs, nil)
+}
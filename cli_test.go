@@ -0,0 +1,92 @@
+package nodepacker
+
+import (
+	"errors"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLookupCommand(t *testing.T) {
+	crh := newTestHandler(t)
+
+	tests := []struct {
+		args []string
+		name string
+		rest []string
+		ok   bool
+	}{
+		{[]string{"nodes_pack", "-search"}, "nodes_pack", []string{"-search"}, true},
+		{[]string{"nodes", "pack", "-search"}, "nodes_pack", []string{"-search"}, true},
+		{[]string{"pack"}, "nodes_pack", []string{}, true},
+		{[]string{"nodes"}, "", []string{"nodes"}, false},
+		{[]string{"no", "such"}, "", []string{"no", "such"}, false},
+	}
+	for _, test := range tests {
+		name, rest, ok := crh.lookupCommand(test.args)
+		if name != test.name || !reflect.DeepEqual(rest, test.rest) || ok != test.ok {
+			t.Errorf("%v: expected %s %v %v, got %s %v %v", test.args, test.name, test.rest, test.ok, name, rest, ok)
+		}
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	crh, out := newClusterTestHandler(t)
+
+	err := crh.RunCommand([]string{"nodes", "pack", "-output", "csv", "-machine", "small", "-anchor=none", "-strategy", "ffd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crh.cctx.output != "csv" {
+		t.Errorf("expected the setup flag to set the output format csv, got %q", crh.cctx.output)
+	}
+	if len(crh.cctx.nodes) != 3 || crh.cctx.nodes[0].machine.Name != "small" {
+		t.Errorf("expected the command flags to pack onto 3 small nodes, got %v", nodeNames(crh.cctx.nodes))
+	}
+	if !strings.HasPrefix(out.String(), "pool,rank,name,") {
+		t.Errorf("expected csv output, got %q", out.String())
+	}
+
+	if err := crh.RunCommand([]string{"nodes_add", "small", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(crh.cctx.nodes) != 5 {
+		t.Errorf("expected the arguments to be passed on, got %v", nodeNames(crh.cctx.nodes))
+	}
+
+	if err := crh.RunCommand([]string{"nodes_add", "large"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a failed command to return its error, got %v", err)
+	}
+	if err := crh.RunCommand([]string{"nodes_pack", "-no-such-flag"}); err == nil {
+		t.Error("expected an unknown flag to fail")
+	}
+	if err := crh.RunCommand([]string{"no_such_command"}); err == nil {
+		t.Error("expected an unknown command to fail")
+	}
+	if err := crh.RunCommand(nil); err == nil {
+		t.Error("expected a missing command to fail")
+	}
+}
+
+func TestRunCommandHelp(t *testing.T) {
+	crh, out := newClusterTestHandler(t)
+
+	err := crh.RunCommand([]string{"pack", "-help"})
+	if err != flag.ErrHelp {
+		t.Errorf("expected flag.ErrHelp, got %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "usage: nodepacker nodes_pack [flags]") ||
+		!strings.Contains(out.String(), "-manifests") || !strings.Contains(out.String(), "-strategy") {
+		t.Errorf("expected the usage with the setup and command flags, got %q", out.String())
+	}
+}
+
+func TestPrintCommands(t *testing.T) {
+	crh, out := newClusterTestHandler(t)
+
+	crh.PrintCommands()
+	if !strings.Contains(out.String(), "  nodes_pack ") || !strings.Contains(out.String(), "  pods_show ") {
+		t.Errorf("expected the commands on the info output, got %q", out.String())
+	}
+}
@@ -1,17 +1,95 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
+
 	"nodepacker"
 	"github.com/c-bata/go-prompt"
 )
 
 func main() {
-	crh := nodepacker.NewCommandReplHandler()
+	statements := flag.String("c", "", "execute the ;-separated statements and exit")
+	script := flag.String("f", "", "execute the statements of the script file and exit")
+	configPath := flag.String("config", "", "config file, by default $XDG_CONFIG_HOME/nodepacker/config.yaml or ~/.nodepacker/config.yaml")
+	historySize := flag.Int("history-size", nodepacker.DefaultHistorySize, "number of REPL statements kept in ~/.nodepacker/history, 0 disables the history")
+	flag.Usage = usage
+	flag.Parse()
+
+	crh, err := nodepacker.NewCommandReplHandler(*configPath)
+	if err != nil {
+		exit(err)
+	}
+	if flag.NArg() > 0 || *statements != "" || *script != "" || !isTerminal(os.Stdin) {
+		// keep stdout for the output of the commands
+		crh.SetOutput(os.Stdout, os.Stderr)
+	}
+
+	switch {
+	case *statements != "":
+		exit(crh.Execute(*statements))
+	case *script != "":
+		exit(crh.ExecuteFile(*script))
+	case flag.NArg() == 1 && flag.Arg(0) == "help":
+		usage()
+		crh.PrintCommands()
+		exit(nil)
+	case flag.NArg() > 0 && flag.Arg(0) != "repl":
+		exit(crh.RunCommand(flag.Args()))
+	case flag.NArg() == 0 && !isTerminal(os.Stdin):
+		exit(crh.ExecuteScript(os.Stdin, "stdin"))
+	}
 
-	pr := prompt.New(crh.ExecuteStatement,
-		crh.Completer,
+	options := []prompt.Option{
 		prompt.OptionPrefix("nodepacker> "),
-		prompt.OptionTitle("nodepacker prompt"))
+		prompt.OptionTitle("nodepacker prompt"),
+	}
+	if *historySize > 0 {
+		history, err := crh.EnableHistory(*historySize)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "warning: failed to load the history:", err)
+		}
+		options = append(options,
+			prompt.OptionHistory(history),
+			prompt.OptionAddKeyBind(prompt.KeyBind{Key: prompt.ControlR, Fn: crh.ReverseSearch}))
+	}
+
+	pr := prompt.New(crh.ExecuteStatement, crh.Completer, options...)
 
 	pr.Run()
 }
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "usage: nodepacker [repl]")
+	fmt.Fprintln(out, "       nodepacker -c <statements> | -f <script>")
+	fmt.Fprintln(out, "       nodepacker <command> [flags] [args...]")
+	fmt.Fprintln(out, "       nodepacker help")
+	fmt.Fprintln(out)
+	flag.PrintDefaults()
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "commands can be given by their REPL name (nodes_pack) or split in two (nodes pack),")
+	fmt.Fprintln(out, "nodepacker <command> --help shows the flags of a command.")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "commands:")
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+func exit(err error) {
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
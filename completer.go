@@ -1,11 +1,13 @@
 package nodepacker
 
 import (
+	"flag"
 	"io/ioutil"
 	"path/filepath"
 	"sort"
 	"strings"
 
+	"nodepacker/filter"
 	"nodepacker/types"
 	"github.com/c-bata/go-prompt"
 )
@@ -37,34 +39,155 @@ func (cc *commandCompleter) complete(cmdPrefix string) []prompt.Suggest {
 }
 
 func (crh *CommandReplHandler) Completer(d prompt.Document) []prompt.Suggest {
-	prefix := d.TextBeforeCursor()
-	endsWithSpace := strings.HasSuffix(prefix, " ")
-	prefix = strings.TrimSpace(prefix)
+	st := lastStatement(d.TextBeforeCursor())
+	words, quoted := st.words, st.quoted
+	if len(words) == 1 {
+		return crh.cc.complete(words[0])
+	}
 
-	if len(prefix) == 0 {
-		return crh.cc.complete(prefix)
+	cs, ok := crh.specs[words[0]]
+	if !ok {
+		return nil
 	}
 
-	parts := strings.Fields(prefix)
-	if len(parts) == 1 && !endsWithSpace {
-		return crh.cc.complete(prefix)
+	// the arguments of an alias without parameters are completed as those of its statement
+	if n, all := aliasParams(cs.alias); cs.alias != "" && n == 0 && !all {
+		sts, _ := splitStatements(cs.alias)
+		if len(sts) != 1 {
+			return nil
+		}
+		words = append(sts[0].words, words[1:]...)
+		quoted = append(sts[0].quoted, quoted[1:]...)
+		if cs, ok = crh.specs[words[0]]; !ok || cs.alias != "" {
+			return nil
+		}
 	}
+	return cs.complete(words[1:], quoted[1:], crh.cctx)
+}
 
-	acs := crh.argsCompleter[parts[0]]
-	if acs == nil {
-		return nil
+// complete suggests completions of the last of the arguments of the command, quoted tells which of
+// them were quoted. Like flag.FlagSet parses them, flags come first and the first argument that
+// isn't a flag ends them.
+func (cs *commandSpec) complete(args []string, quoted []bool, cctx *CommandContext) []prompt.Suggest {
+	last := args[len(args)-1]
+
+	var fs *flag.FlagSet
+	if cs.flagSet != nil {
+		fs = cs.flagSet()
+	}
+
+	i := 0
+	for fs != nil && i < len(args)-1 {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			fs = nil
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		i++
+
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		f := fs.Lookup(name)
+		if f == nil || isBoolFlag(f) {
+			continue
+		}
+		// the flag takes the next argument as its value
+		if i == len(args)-1 {
+			return cs.completeFlagValue(name, "", last, cctx)
+		}
+		i++
+	}
+
+	// the last argument is the first one that isn't a flag value
+	var res []prompt.Suggest
+	if fs != nil && i == len(args)-1 {
+		if strings.HasPrefix(last, "-") {
+			if eq := strings.Index(last, "="); eq >= 0 {
+				return cs.completeFlagValue(strings.TrimLeft(last[:eq], "-"), last[:eq+1], last[eq+1:], cctx)
+			}
+			return flagComplete(fs, last)
+		}
+		if last == "" {
+			res = flagComplete(fs, "-")
+		}
+	}
+
+	positional := args[i:]
+	if cs.filter {
+		return append(res, filterComplete(positional, quoted[i:], cs.filterNames, cctx)...)
 	}
-	n := len(parts)
-	partial := parts[n-1]
-	pos := n - 1
-	if endsWithSpace {
-		pos += 1
-		partial = ""
+	pos := len(positional) - 1
+	if pos < len(cs.args) && cs.args[pos].complete != nil {
+		res = append(res, cs.args[pos].complete(last, cctx)...)
 	}
-	if len(acs) <= pos {
+	return res
+}
+
+// completeFlagValue completes the value of a flag, before is the text before the value in the word
+func (cs *commandSpec) completeFlagValue(name, before, prefix string, cctx *CommandContext) []prompt.Suggest {
+	ac := cs.flagValues[name]
+	if ac == nil {
 		return nil
 	}
-	return acs[pos](partial, crh.cctx)
+	var res []prompt.Suggest
+	for _, s := range ac(prefix, cctx) {
+		res = append(res, prompt.Suggest{Text: before + s.Text, Description: s.Description})
+	}
+	return res
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+// flagComplete completes the names of the flags of a flag set, keeping the dashes of the prefix
+func flagComplete(fs *flag.FlagSet, prefix string) []prompt.Suggest {
+	name := strings.TrimLeft(prefix, "-")
+	dashes := prefix[:len(prefix)-len(name)]
+
+	var res []prompt.Suggest
+	fs.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, name) {
+			res = append(res, prompt.Suggest{Text: dashes + f.Name, Description: f.Usage})
+		}
+	})
+	return res
+}
+
+// filterComplete completes filter expressions, names completes the values of name comparisons
+func filterComplete(args []string, quoted []bool, names ArgsCompleter, cctx *CommandContext) []prompt.Suggest {
+	values := make(map[string]string)
+	if names != nil {
+		for _, s := range names("", cctx) {
+			values[s.Text] = s.Description
+		}
+	}
+
+	var res []prompt.Suggest
+	for _, s := range filter.Complete(args, quoted, values) {
+		res = append(res, prompt.Suggest{Text: s.Text, Description: s.Description})
+	}
+	return res
+}
+
+// valuesComplete returns a completer of a fixed set of values
+func valuesComplete(values ...string) ArgsCompleter {
+	return func(prefix string, cctx *CommandContext) []prompt.Suggest {
+		var res []prompt.Suggest
+		for _, v := range values {
+			if strings.HasPrefix(v, prefix) {
+				res = append(res, prompt.Suggest{Text: v})
+			}
+		}
+		return res
+	}
 }
 
 func pathComplete(prefix string, cctx *CommandContext) []prompt.Suggest {
@@ -108,6 +231,39 @@ func machineComplete(prefix string, cctx *CommandContext) []prompt.Suggest {
 	return res
 }
 
+func podComplete(prefix string, cctx *CommandContext) []prompt.Suggest {
+	var res []prompt.Suggest
+
+	for k, v := range cctx.pods {
+		if strings.HasPrefix(k, prefix) {
+			res = append(res, prompt.Suggest{Text: k, Description: types.HumanReadableMemCPU(v)})
+		}
+	}
+	return res
+}
+
+func nodeComplete(prefix string, cctx *CommandContext) []prompt.Suggest {
+	var res []prompt.Suggest
+
+	for _, node := range cctx.nodes {
+		if strings.HasPrefix(node.name, prefix) {
+			res = append(res, prompt.Suggest{Text: node.name, Description: node.machine.Name})
+		}
+	}
+	return res
+}
+
+func scenarioComplete(prefix string, cctx *CommandContext) []prompt.Suggest {
+	var res []prompt.Suggest
+
+	for _, name := range cctx.scenarioNames() {
+		if strings.HasPrefix(name, prefix) {
+			res = append(res, prompt.Suggest{Text: name})
+		}
+	}
+	return res
+}
+
 func zoneComplete(prefix string, cctx *CommandContext) []prompt.Suggest {
 	var res []prompt.Suggest
 
@@ -118,3 +274,40 @@ func zoneComplete(prefix string, cctx *CommandContext) []prompt.Suggest {
 	}
 	return res
 }
+
+func outputComplete(prefix string, cctx *CommandContext) []prompt.Suggest {
+	var res []prompt.Suggest
+
+	for _, f := range outputFormats {
+		if strings.HasPrefix(f, prefix) {
+			res = append(res, prompt.Suggest{Text: f})
+		}
+	}
+	return res
+}
+
+func commandComplete(prefix string, cctx *CommandContext) []prompt.Suggest {
+	var res []prompt.Suggest
+
+	for name, cs := range cctx.specs {
+		if strings.HasPrefix(name, prefix) {
+			res = append(res, prompt.Suggest{Text: name, Description: cs.description})
+		}
+	}
+	if strings.HasPrefix("filter", prefix) {
+		res = append(res, prompt.Suggest{Text: "filter", Description: "filter expressions"})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Text < res[j].Text })
+	return res
+}
+
+func aliasComplete(prefix string, cctx *CommandContext) []prompt.Suggest {
+	var res []prompt.Suggest
+
+	for _, name := range sortedAliasNames(cctx) {
+		if strings.HasPrefix(name, prefix) {
+			res = append(res, prompt.Suggest{Text: name, Description: cctx.aliases[name]})
+		}
+	}
+	return res
+}
@@ -0,0 +1,47 @@
+package nodepacker
+
+import (
+	"reflect"
+	"testing"
+
+	"nodepacker/types"
+	"github.com/c-bata/go-prompt"
+)
+
+func TestCompleter(t *testing.T) {
+	crh := newTestHandler(t)
+	crh.cctx.zone = "test-zone"
+	crh.cctx.machines = map[string]map[string]types.Resource{
+		"test-zone": {"n2-standard-8": {Name: "n2-standard-8", CPU: 8000, Memory: 32000}},
+	}
+	crh.cctx.pods = map[string]types.Resource{"web-0": {Name: "web-0", CPU: 500, Memory: 1000}}
+
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"machines_show -so", []string{"-sort"}},
+		{"machines_show -sort ", []string{"cpu", "mem", "price"}},
+		{"machines_show -sort=p", []string{"-sort=price"}},
+		{"machines_show -sort mem c", []string{"cpu"}},
+		{"machines_show cpu >", []string{">", ">="}},
+		{"machines_show name = n2", []string{"'n2-standard-8'"}},
+		{"pods_show -o csv name ~= 'we", []string{"'web-0'"}},
+		{"pods_show name = 'web-0' ", []string{"&", "|"}},
+		{"pods_show name = 'cpu' | name = '8' ", []string{"&", "|"}},
+		{"nodes_pack -search -rank w", []string{"waste"}},
+		{"nodes_pack -machine n2", []string{"n2-standard-8"}},
+		{"pods_pin w", []string{"web-0"}},
+	}
+	for _, test := range tests {
+		buf := prompt.NewBuffer()
+		buf.InsertText(test.line, false, true)
+		var got []string
+		for _, s := range crh.Completer(*buf.Document()) {
+			got = append(got, s.Text)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: expected %v, got %v", test.line, test.want, got)
+		}
+	}
+}
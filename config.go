@@ -0,0 +1,179 @@
+package nodepacker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"nodepacker/types"
+	"gopkg.in/yaml.v3"
+)
+
+// config is the user configuration, for example
+//
+//	provider: gcp
+//	zone: us-east1-b
+//	cacheDir: /var/cache/nodepacker
+//	strategy: bfd
+//	headroom:
+//	  cpu: 10
+//	  memory: 15
+//	output: table
+//	units: GiB
+//	aliases:
+//	  pk: manifests_read $1; nodes_pack -search -rank price
+//
+// It is read from the file given with --config, $XDG_CONFIG_HOME/nodepacker/config.yaml if
+// XDG_CONFIG_HOME is set and ~/.nodepacker/config.yaml otherwise. The environment variables in
+// envOverrides take precedence over the file.
+type config struct {
+	// provider the machine types and prices come from, gcp, aws or azure
+	Provider string `yaml:"provider,omitempty"`
+	// zone nodepacker starts in, the default zone of the provider if empty
+	Zone string `yaml:"zone,omitempty"`
+	// directory of the machine and price caches, ~/.nodepacker by default
+	CacheDir string `yaml:"cacheDir,omitempty"`
+	// default binpacking strategy of nodes_pack
+	Strategy string `yaml:"strategy,omitempty"`
+	// default percentages of the CPU and memory of each node nodes_pack keeps free
+	Headroom headroomConfig `yaml:"headroom,omitempty"`
+	// default output format of the commands with a -o flag
+	Output string `yaml:"output,omitempty"`
+	// unit memory is shown in, GB or GiB
+	Units string `yaml:"units,omitempty"`
+	// statements of the aliases by name, see alias
+	Aliases map[string]string `yaml:"aliases,omitempty"`
+}
+
+type headroomConfig struct {
+	CPU    int `yaml:"cpu,omitempty"`
+	Memory int `yaml:"memory,omitempty"`
+}
+
+// envOverrides are the environment variables overriding settings of the config file
+var envOverrides = []struct {
+	name string
+	set  func(c *config, value string) error
+}{
+	{"NODEPACKER_PROVIDER", func(c *config, v string) error { c.Provider = v; return nil }},
+	{"NODEPACKER_ZONE", func(c *config, v string) error { c.Zone = v; return nil }},
+	{"NODEPACKER_CACHE_DIR", func(c *config, v string) error { c.CacheDir = v; return nil }},
+	{"NODEPACKER_STRATEGY", func(c *config, v string) error { c.Strategy = v; return nil }},
+	{"NODEPACKER_HEADROOM_CPU", func(c *config, v string) (err error) { c.Headroom.CPU, err = strconv.Atoi(v); return }},
+	{"NODEPACKER_HEADROOM_MEM", func(c *config, v string) (err error) { c.Headroom.Memory, err = strconv.Atoi(v); return }},
+	{"NODEPACKER_OUTPUT", func(c *config, v string) error { c.Output = v; return nil }},
+	{"NODEPACKER_UNITS", func(c *config, v string) error { c.Units = v; return nil }},
+}
+
+// defaultConfigPath returns the path of the config file if --config isn't given, see config
+func defaultConfigPath() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "nodepacker", "config.yaml"), nil
+	}
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(usr.HomeDir, ".nodepacker", "config.yaml"), nil
+}
+
+// loadConfig reads the config file, applies the environment overrides and the defaults and
+// validates the result
+func loadConfig(path string) (*config, error) {
+	c, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range envOverrides {
+		if v, ok := os.LookupEnv(o.name); ok {
+			err := o.set(c, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", o.name, err)
+			}
+		}
+	}
+
+	if c.Provider == "" {
+		c.Provider = providers[0].Name()
+	}
+	p, err := lookupProvider(c.Provider)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	if c.Zone == "" {
+		c.Zone = p.DefaultZone()
+	}
+	if c.CacheDir == "" {
+		usr, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		c.CacheDir = filepath.Join(usr.HomeDir, ".nodepacker")
+	}
+	if c.Strategy == "" {
+		c.Strategy = packStrategies[0]
+	}
+	if c.Output == "" {
+		c.Output = outputTable
+	}
+	if c.Units == "" {
+		c.Units = types.UnitGB
+	}
+
+	ps := packStrategy{name: c.Strategy, headroom: headroom{cpu: c.Headroom.CPU, memory: c.Headroom.Memory}}
+	if err := ps.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	if err := validOutputFormat(c.Output); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	if c.Units, err = types.ParseMemoryUnit(c.Units); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// readConfig reads the config file as it is, a missing file is an empty configuration
+func readConfig(path string) (*config, error) {
+	var c config
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d := yaml.NewDecoder(bufio.NewReader(f))
+	err = d.Decode(&c)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to decode config %s: %v", path, err)
+	}
+	return &c, nil
+}
+
+func saveConfig(path string, c *config) error {
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	bf := bufio.NewWriter(f)
+	defer bf.Flush()
+
+	e := yaml.NewEncoder(bf)
+	return e.Encode(c)
+}
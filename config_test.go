@@ -0,0 +1,37 @@
+package nodepacker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"nodepacker/types"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := ioutil.WriteFile(path, []byte("zone: us-east1-b\nstrategy: bfd\nheadroom:\n  cpu: 10\nunits: GiB\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("NODEPACKER_STRATEGY", "ffd")
+	defer os.Unsetenv("NODEPACKER_STRATEGY")
+
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Zone != "us-east1-b" || c.Strategy != "ffd" || c.Headroom.CPU != 10 || c.Output != outputTable {
+		t.Errorf("unexpected config %+v", c)
+	}
+	if c.Units != types.UnitGiB {
+		t.Errorf("expected memory in GiB, got %s", c.Units)
+	}
+
+	os.Setenv("NODEPACKER_STRATEGY", "nope")
+	if _, err := loadConfig(path); err == nil {
+		t.Error("expected an unknown strategy to be rejected")
+	}
+}
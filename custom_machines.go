@@ -0,0 +1,227 @@
+package nodepacker
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+
+	"nodepacker/types"
+)
+
+// memory of custom machine types is a multiple of 256 MiB
+const customMemoryStep = 256
+
+// customFamily generates the custom machine types of a GCE machine family. Custom machine types
+// are named <prefix>-<vCPUs>-<memory in MiB>, for example n2-custom-12-73728.
+type customFamily struct {
+	family string
+	// n1 custom machine types are named custom-<vCPUs>-<memory>
+	prefix string
+	// allowed numbers of vCPUs
+	cpus []int64
+	// minimum and maximum memory per vCPU in MiB
+	minMemory, maxMemory int64
+	// maximum memory of a machine in MiB, 0 if only limited per vCPU
+	maxTotalMemory int64
+	// list prices in us-central1, used unless the price catalog of a zone has custom prices for
+	// the family
+	price types.CustomPrice
+}
+
+// cpuRange returns the numbers from first to last in steps of step
+func cpuRange(first, last, step int64) []int64 {
+	var cpus []int64
+	for c := first; c <= last; c += step {
+		cpus = append(cpus, c)
+	}
+	return cpus
+}
+
+// customFamilies are the families with custom machine types, extended memory isn't modelled
+var customFamilies = []customFamily{
+	{
+		family:    "n1",
+		prefix:    "custom",
+		cpus:      append([]int64{1}, cpuRange(2, 96, 2)...),
+		minMemory: 922,
+		maxMemory: 6656,
+		price: types.CustomPrice{
+			CPU:    types.Price{OnDemand: 0.033174, Spot: 0.00698, Commit1Y: 0.019915, Commit3Y: 0.014225},
+			Memory: types.Price{OnDemand: 0.004446, Spot: 0.00094, Commit1Y: 0.002669, Commit3Y: 0.001907},
+		},
+	},
+	{
+		family:    "n2",
+		prefix:    "n2-custom",
+		cpus:      append(cpuRange(2, 32, 2), cpuRange(36, 80, 4)...),
+		minMemory: 512,
+		maxMemory: 8192,
+		price: types.CustomPrice{
+			CPU:    types.Price{OnDemand: 0.033191, Spot: 0.00804, Commit1Y: 0.019915, Commit3Y: 0.014225},
+			Memory: types.Price{OnDemand: 0.004449, Spot: 0.00108, Commit1Y: 0.002669, Commit3Y: 0.001907},
+		},
+	},
+	{
+		family:         "e2",
+		prefix:         "e2-custom",
+		cpus:           cpuRange(2, 32, 2),
+		minMemory:      512,
+		maxMemory:      8192,
+		maxTotalMemory: 131072,
+		price: types.CustomPrice{
+			CPU:    types.Price{OnDemand: 0.022890, Spot: 0.00654, Commit1Y: 0.013741, Commit3Y: 0.009815},
+			Memory: types.Price{OnDemand: 0.003067, Spot: 0.000877, Commit1Y: 0.001842, Commit3Y: 0.001316},
+		},
+	},
+}
+
+var customMachineName = regexp.MustCompile(`^((?:[a-z][a-z0-9]*-)?custom)-([0-9]+)-([0-9]+)$`)
+
+// validShape checks the constraints of the family for a machine with the given vCPUs and MiB
+func (cf customFamily) validShape(cpus, memory int64) error {
+	allowed := false
+	for _, c := range cf.cpus {
+		allowed = allowed || c == cpus
+	}
+	if !allowed {
+		return fmt.Errorf("%s custom machine types can't have %d vCPUs", cf.family, cpus)
+	}
+	if memory%customMemoryStep != 0 {
+		return fmt.Errorf("memory of custom machine types has to be a multiple of %d MiB", customMemoryStep)
+	}
+	if memory < cf.minMemory*cpus || memory > cf.maxMemory*cpus {
+		return fmt.Errorf("%s custom machine types need %d to %d MiB of memory per vCPU", cf.family,
+			cf.minMemory, cf.maxMemory)
+	}
+	if cf.maxTotalMemory > 0 && memory > cf.maxTotalMemory {
+		return fmt.Errorf("%s custom machine types have at most %d MiB of memory", cf.family, cf.maxTotalMemory)
+	}
+	return nil
+}
+
+// machine returns the custom machine type with the given vCPUs and MiB. Memory is converted to MB
+// counting a GiB as 1000 MB like gcloud does for the predefined machine types.
+func (cf customFamily) machine(cpus, memory int64) types.Resource {
+	return describeGCE(types.Resource{
+		Name:    fmt.Sprintf("%s-%d-%d", cf.prefix, cpus, memory),
+		CPU:     cpus * 1000,
+		Memory:  int64(math.Round(float64(memory) * 1000 / 1024)),
+		Machine: types.MachineInfo{Family: cf.family},
+	})
+}
+
+// shapes returns a custom machine type for every allowed number of vCPUs, with the memory closest
+// to memoryPerCPU, in MB per vCPU, the constraints allow
+func (cf customFamily) shapes(memoryPerCPU float64) []types.Resource {
+	var ms []types.Resource
+	for _, cpus := range cf.cpus {
+		// MB to MiB rounded up to the memory step
+		memory := int64(math.Ceil(float64(cpus)*memoryPerCPU*1024/1000/customMemoryStep)) * customMemoryStep
+		minMemory := (cf.minMemory*cpus + customMemoryStep - 1) / customMemoryStep * customMemoryStep
+		maxMemory := cf.maxMemory * cpus / customMemoryStep * customMemoryStep
+		if cf.maxTotalMemory > 0 && maxMemory > cf.maxTotalMemory {
+			maxMemory = cf.maxTotalMemory
+		}
+		if minMemory > maxMemory {
+			continue
+		}
+		if memory < minMemory {
+			memory = minMemory
+		}
+		if memory > maxMemory {
+			memory = maxMemory
+		}
+		ms = append(ms, cf.machine(cpus, memory))
+	}
+	return ms
+}
+
+// priceOf returns the price of a custom machine type in a zone, memory in MB is GB times 1000
+func (cf customFamily) priceOf(m types.Resource, zp types.ZonePrices) types.Price {
+	cp, ok := zp.Custom[cf.family]
+	if !ok {
+		cp = cf.price
+	}
+	return cp.Of(float64(m.CPU)/1000, float64(m.Memory)/1000)
+}
+
+// parseCustomMachine returns the family, vCPUs and MiB of a custom machine type name
+func parseCustomMachine(name string) (customFamily, int64, int64, error) {
+	match := customMachineName.FindStringSubmatch(name)
+	if match == nil {
+		return customFamily{}, 0, 0, fmt.Errorf("%s isn't a custom machine type, expected [<family>-]custom-<vCPUs>-<MiB>", name)
+	}
+	cpus, _ := strconv.ParseInt(match[2], 10, 64)
+	memory, _ := strconv.ParseInt(match[3], 10, 64)
+	for _, cf := range customFamilies {
+		if cf.prefix == match[1] {
+			return cf, cpus, memory, cf.validShape(cpus, memory)
+		}
+	}
+	return customFamily{}, 0, 0, fmt.Errorf("unknown custom machine type family %s", match[1])
+}
+
+// customMachine returns a custom machine type by name if its family is available in the zone of ms
+func customMachine(name string, ms map[string]types.Resource) (types.Resource, bool) {
+	cf, cpus, memory, err := parseCustomMachine(name)
+	if err != nil || !familyAvailable(cf.family, ms) {
+		return types.Resource{}, false
+	}
+	return cf.machine(cpus, memory), true
+}
+
+// familyAvailable reports whether ms has predefined machine types of a family
+func familyAvailable(family string, ms map[string]types.Resource) bool {
+	for name := range ms {
+		if len(name) > len(family) && name[:len(family)+1] == family+"-" {
+			return true
+		}
+	}
+	return false
+}
+
+// customShapes returns custom machine types shaped for pods of the families available in the zone
+// of ms. Their memory per vCPU is that of the pods, so that a node runs out of CPU and memory at
+// about the same time.
+func customShapes(pods map[string]types.Resource, h headroom, ms map[string]types.Resource) []types.Resource {
+	need := h.needed(types.SumResourceMap(pods))
+	// the memory per vCPU of n1-standard machine types if the pods don't request CPU
+	memoryPerCPU := 3750.0
+	if need.CPU > 0 {
+		memoryPerCPU = float64(need.Memory) * 1000 / float64(need.CPU)
+	}
+
+	var shapes []types.Resource
+	for _, cf := range customFamilies {
+		if familyAvailable(cf.family, ms) {
+			shapes = append(shapes, cf.shapes(memoryPerCPU)...)
+		}
+	}
+	return shapes
+}
+
+// withCustomMachines returns copies of the machines and prices of a zone with custom machine types
+// and their prices added
+func withCustomMachines(ms map[string]types.Resource, zp types.ZonePrices,
+	customs []types.Resource) (map[string]types.Resource, types.ZonePrices) {
+	cms := make(map[string]types.Resource, len(ms)+len(customs))
+	for name, m := range ms {
+		cms[name] = m
+	}
+	czp := zp
+	czp.Machines = make(map[string]types.Price, len(zp.Machines)+len(customs))
+	for name, p := range zp.Machines {
+		czp.Machines[name] = p
+	}
+
+	for _, m := range customs {
+		cf, _, _, err := parseCustomMachine(m.Name)
+		if err != nil {
+			continue
+		}
+		cms[m.Name] = m
+		czp.Machines[m.Name] = cf.priceOf(m, zp)
+	}
+	return cms, czp
+}
@@ -0,0 +1,118 @@
+package nodepacker
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"nodepacker/types"
+)
+
+func TestParseCustomMachine(t *testing.T) {
+	tests := []struct {
+		name   string
+		family string
+		valid  bool
+	}{
+		{"n2-custom-12-73728", "n2", true},
+		{"custom-1-3840", "n1", true},
+		{"e2-custom-4-8192", "e2", true},
+		{"n2-custom-3-8192", "n2", false},
+		{"n2-custom-2-1000", "n2", false},
+		{"n1-custom-4-8192", "", false},
+		{"e2-custom-32-262144", "e2", false},
+		{"n1-standard-4", "", false},
+	}
+	for _, test := range tests {
+		cf, _, _, err := parseCustomMachine(test.name)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+		if cf.family != test.family {
+			t.Errorf("%s: expected family %q, got %q", test.name, test.family, cf.family)
+		}
+	}
+}
+
+func TestCustomShapes(t *testing.T) {
+	for _, cf := range customFamilies {
+		for _, ratio := range []float64{100, 2000, 6000, 20000} {
+			for _, m := range cf.shapes(ratio) {
+				_, cpus, memory, err := parseCustomMachine(m.Name)
+				if err != nil {
+					t.Errorf("%s shape for %v MB per vCPU: %v", cf.family, ratio, err)
+					continue
+				}
+				if m.CPU != cpus*1000 || m.Memory != int64(math.Round(float64(memory)*1000/1024)) {
+					t.Errorf("unexpected resources of %s: %+v", m.Name, m)
+				}
+			}
+		}
+	}
+
+	// 12 vCPUs with 6 GB each
+	n2 := customFamilies[1]
+	var found bool
+	for _, m := range n2.shapes(6000) {
+		found = found || m.Name == "n2-custom-12-73728"
+	}
+	if !found {
+		t.Error("expected n2-custom-12-73728 for 6 GB per vCPU")
+	}
+
+	p := n2.priceOf(n2.machine(12, 73728), types.ZonePrices{})
+	want := 12*n2.price.CPU.OnDemand + 72*n2.price.Memory.OnDemand
+	if math.Abs(p.OnDemand-want) > 1e-9 {
+		t.Errorf("expected on-demand price %v, got %v", want, p.OnDemand)
+	}
+}
+
+func TestPackCustomMachines(t *testing.T) {
+	crh := newTestHandler(t)
+	var out bytes.Buffer
+	crh.SetOutput(&out, &out)
+
+	crh.cctx.zone = "us-central1-a"
+	crh.cctx.machines = types.Machines{"us-central1-a": {
+		"n2-standard-8": {Name: "n2-standard-8", CPU: 8000, Memory: 32000},
+	}}
+	crh.cctx.pods = make(map[string]types.Resource)
+	for _, name := range []string{"web-0", "web-1", "web-2"} {
+		crh.cctx.pods[name] = types.Resource{Name: name, CPU: 3000, Memory: 20000}
+	}
+
+	err := crh.Execute("nodes_pack -anchor none -search -custom -rank nodes -o csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "n2-custom-") {
+		t.Errorf("expected a custom machine type plan, got\n%s", out.String())
+	}
+	if crh.cctx.nodes[0].machine.Name == "n2-standard-8" {
+		t.Errorf("expected a custom machine type to need fewer nodes than n2-standard-8")
+	}
+
+	err = crh.Execute("nodes_add n2-custom-12-73728")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = crh.Execute("nodes_add n2-custom-3-8192")
+	if err == nil {
+		t.Error("expected an invalid custom machine type to fail")
+	}
+
+	err = crh.Execute("machines_provider aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = crh.Execute("nodes_pack -search -custom")
+	if !errors.Is(err, ErrInvalidArgs) {
+		t.Errorf("expected -custom to fail for aws with ErrInvalidArgs, got %v", err)
+	}
+	err = crh.Execute("nodes_add n2-custom-12-73728")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected custom machine types to be unknown for aws, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package nodepacker
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+)
+
+// kinds of command errors, test for them with errors.Is
+var (
+	// the statement names no command
+	ErrUnknownCommand = errors.New("unknown command")
+	// the arguments or flags of the command are invalid
+	ErrInvalidArgs = errors.New("invalid arguments")
+	// the command needs state that is missing, e.g. packing without pods
+	ErrMissingState = errors.New("missing state")
+	// a pod, node, machine type, zone or scenario the command refers to doesn't exist
+	ErrNotFound = errors.New("not found")
+)
+
+// CommandError is the error of a failed command
+type CommandError struct {
+	Command string
+	Args    []string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// kindError is an error of one of the kinds above with a message for the user
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+func errorf(kind error, format string, a ...interface{}) error {
+	return &kindError{kind: kind, msg: fmt.Sprintf(format, a...)}
+}
+
+func argsErrorf(format string, a ...interface{}) error {
+	return errorf(ErrInvalidArgs, format, a...)
+}
+
+func missingErrorf(format string, a ...interface{}) error {
+	return errorf(ErrMissingState, format, a...)
+}
+
+func notFoundErrorf(format string, a ...interface{}) error {
+	return errorf(ErrNotFound, format, a...)
+}
+
+// errHelp is returned by parseFlags if -h or -help was given and the usage has been printed. The
+// command returns it and its caller treats it as success.
+var errHelp = flag.ErrHelp
+
+// parseFlags parses the arguments of a command with its flag set, printing the usage to the
+// output of the command if help is requested
+func parseFlags(cctx *CommandContext, fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(ioutil.Discard)
+	err := fs.Parse(args)
+	if err == flag.ErrHelp {
+		fs.SetOutput(cctx.out)
+		fmt.Fprintf(cctx.out, "usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
+		return errHelp
+	}
+	if err != nil {
+		return argsErrorf("%v", err)
+	}
+	return nil
+}
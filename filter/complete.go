@@ -0,0 +1,240 @@
+package filter
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/participle/lexer"
+	"github.com/alecthomas/participle/lexer/ebnf"
+)
+
+// Suggestion is a completion of the last word of a filter expression
+type Suggestion struct {
+	Text        string
+	Description string
+}
+
+// what the grammar expects next while an expression is typed
+type expectation int
+
+const (
+	expectField expectation = iota
+	expectNumericOp
+	expectStringOp
+	expectBoolOp
+	expectNatural
+	expectString
+	expectBool
+	expectConnective
+)
+
+var fieldSuggestions = []Suggestion{
+	{"name", "name, compared with = != ~="},
+	{"cpu", "CPU in vCPU"},
+	{"mem", "memory in GB, whatever the units setting"},
+	{"family", "machine family, compared with = != ~="},
+	{"arch", "CPU architecture, x86 or arm64"},
+	{"gen", "generation of the machine family"},
+	{"net", "network bandwidth in Gbps"},
+	{"disks", "maximum number of persistent disks"},
+	{"shared", "whether the CPU is shared"},
+	{"localssd", "whether local SSDs can be attached"},
+	{"deprecated", "whether the machine type is deprecated"},
+	{"(", "start a group"},
+}
+
+var numericOpSuggestions = []Suggestion{
+	{"=", "equal"},
+	{"!=", "not equal"},
+	{"<", "less"},
+	{"<=", "less or equal"},
+	{">", "greater"},
+	{">=", "greater or equal"},
+}
+
+var stringOpSuggestions = []Suggestion{
+	{"=", "equal"},
+	{"!=", "not equal"},
+	{"~=", "matches the regular expression"},
+}
+
+var boolOpSuggestions = []Suggestion{
+	{"=", "equal"},
+	{"!=", "not equal"},
+}
+
+var archSuggestions = map[string]string{
+	"x86":   "x86-64",
+	"arm64": "64-bit Arm",
+}
+
+// completion follows the tokens of an expression through the grammar
+type completion struct {
+	symbols map[rune]string
+	expect  expectation
+	// the field of the comparison being typed
+	field string
+	// number of open parentheses
+	depth int
+	// whether a token didn't fit the grammar, nothing is suggested then
+	invalid bool
+}
+
+func (c *completion) next(t lexer.Token) {
+	symbol := c.symbols[t.Type]
+	switch {
+	case c.invalid || symbol == "Whitespace":
+		return
+	case c.expect == expectField && symbol == "NumericField":
+		c.expect, c.field = expectNumericOp, t.Value
+	case c.expect == expectField && symbol == "StringField":
+		c.expect, c.field = expectStringOp, t.Value
+	case c.expect == expectField && symbol == "BoolField":
+		c.expect, c.field = expectBoolOp, t.Value
+	case c.expect == expectField && t.Value == "(":
+		c.depth++
+	case c.expect == expectNumericOp && (symbol == "NumericOp" || symbol == "Op"):
+		c.expect = expectNatural
+	case c.expect == expectStringOp && (symbol == "StringOp" || symbol == "Op"):
+		c.expect = expectString
+	case c.expect == expectBoolOp && symbol == "Op":
+		c.expect = expectBool
+	case c.expect == expectNatural && symbol == "Natural",
+		c.expect == expectString && symbol == "QuotedString",
+		c.expect == expectBool && symbol == "Bool":
+		c.expect = expectConnective
+	case c.expect == expectConnective && (t.Value == "&" || t.Value == "|"):
+		c.expect = expectField
+	case c.expect == expectConnective && t.Value == ")" && c.depth > 0:
+		c.depth--
+	default:
+		c.invalid = true
+	}
+}
+
+// tokens lexes s, it returns false if s isn't made of filter tokens
+func tokens(def lexer.Definition, s string) ([]lexer.Token, bool) {
+	l, err := def.Lex(strings.NewReader(s))
+	if err != nil {
+		return nil, false
+	}
+	var ts []lexer.Token
+	for {
+		t, err := l.Next()
+		if err != nil {
+			return nil, false
+		}
+		if t.EOF() {
+			return ts, true
+		}
+		ts = append(ts, t)
+	}
+}
+
+// splitWord feeds the tokens of a word but the last one being typed to c and returns the text of
+// those tokens
+func (c *completion) splitWord(def lexer.Definition, word string) string {
+	if ts, ok := tokens(def, word); ok && len(ts) > 0 {
+		last := ts[len(ts)-1]
+		for _, t := range ts[:len(ts)-1] {
+			c.next(t)
+		}
+		return word[:last.Pos.Offset]
+	}
+	for i := len(word) - 1; i > 0; i-- {
+		if ts, ok := tokens(def, word[:i]); ok {
+			for _, t := range ts {
+				c.next(t)
+			}
+			return word[:i]
+		}
+	}
+	return ""
+}
+
+// Complete suggests completions of the last of the words of a filter expression as the REPL splits
+// them, the last word is the one being typed. quoted tells which of the words are values whose
+// quotes the REPL removed, it is nil if none are. A word can hold several tokens, e.g. cpu>=8, the
+// suggestions complete its last token. In name comparisons the names are suggested as quoted
+// strings, names maps them to their descriptions. Families aren't suggested.
+func Complete(words []string, quoted []bool, names map[string]string) []Suggestion {
+	if len(words) == 0 {
+		words = []string{""}
+		quoted = nil
+	}
+	def, err := ebnf.New(lexerSpec)
+	if err != nil {
+		return nil
+	}
+	c := &completion{symbols: lexer.SymbolsByRune(def)}
+
+	n := len(words) - 1
+	var doneQuoted []bool
+	if quoted != nil {
+		doneQuoted = quoted[:n]
+	}
+	expr, err := joinArgs(words[:n], doneQuoted)
+	if err != nil {
+		return nil
+	}
+	done, ok := tokens(def, expr)
+	if !ok {
+		return nil
+	}
+	for _, t := range done {
+		c.next(t)
+	}
+
+	// split the last word into the tokens before the cursor and the prefix of the one being typed,
+	// a quoted word is the prefix of a value as a whole
+	word := words[n]
+	before := ""
+	if quoted == nil || !quoted[n] {
+		before = c.splitWord(def, word)
+	}
+	if c.invalid {
+		return nil
+	}
+	prefix := word[len(before):]
+
+	var candidates []Suggestion
+	switch c.expect {
+	case expectField:
+		candidates = fieldSuggestions
+	case expectNumericOp:
+		candidates = numericOpSuggestions
+	case expectStringOp:
+		candidates = stringOpSuggestions
+	case expectBoolOp:
+		candidates = boolOpSuggestions
+	case expectBool:
+		candidates = []Suggestion{{"true", ""}, {"false", ""}}
+	case expectString:
+		if c.field == "arch" {
+			names = archSuggestions
+		} else if c.field != "name" {
+			break
+		}
+		prefix = strings.TrimPrefix(prefix, "'")
+		for name, description := range names {
+			if strings.HasPrefix(name, prefix) {
+				candidates = append(candidates, Suggestion{"'" + name + "'", description})
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].Text < candidates[j].Text })
+		prefix = "'" + prefix
+	case expectConnective:
+		candidates = []Suggestion{{"&", "and"}, {"|", "or"}}
+		if c.depth > 0 {
+			candidates = append(candidates, Suggestion{")", "end the group"})
+		}
+	}
+
+	var res []Suggestion
+	for _, s := range candidates {
+		if strings.HasPrefix(s.Text, prefix) {
+			res = append(res, Suggestion{before + s.Text, s.Description})
+		}
+	}
+	return res
+}
@@ -0,0 +1,49 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	names := map[string]string{"web-0": "", "web-1": "", "pgsql-0": ""}
+
+	fixture := []struct {
+		words  []string
+		quoted []bool
+		want   []string
+	}{
+		{[]string{""}, nil, []string{"name", "cpu", "mem", "family", "arch", "gen", "net", "disks", "shared", "localssd",
+			"deprecated", "("}},
+		{[]string{"c"}, nil, []string{"cpu"}},
+		{[]string{"cpu", ">"}, nil, []string{">", ">="}},
+		{[]string{"name", "!"}, nil, []string{"!="}},
+		{[]string{"cpu", ">=", "8", ""}, nil, []string{"&", "|"}},
+		{[]string{"cpu>=8&m"}, nil, []string{"cpu>=8&mem"}},
+		{[]string{"(", "name", "~=", "we"}, nil, []string{"'web-0'", "'web-1'"}},
+		{[]string{"(", "name", "=", "web-0", ""}, []bool{false, false, false, true, false}, []string{"&", "|", ")"}},
+		{[]string{"(", "name", "=", "web-0", ""}, nil, nil},
+		{[]string{"name", "=", "cpu", "|", "name", "=", "8", ""}, []bool{false, false, true, false, false, false, true, false},
+			[]string{"&", "|"}},
+		{[]string{"name", "=", "cpu", "|", "name", "=", "we"}, []bool{false, false, true, false, false, false, true},
+			[]string{"'web-0'", "'web-1'"}},
+		{[]string{"name", "=", "pg"}, []bool{false, false, true}, []string{"'pgsql-0'"}},
+		{[]string{"name=pg"}, nil, []string{"name='pgsql-0'"}},
+		{[]string{"cpu", "=", "x", ""}, nil, nil},
+		{[]string{"cpu", "=", "8", ""}, []bool{false, false, true, false}, nil},
+		{[]string{"shared", ""}, nil, []string{"=", "!="}},
+		{[]string{"localssd=f"}, nil, []string{"localssd=false"}},
+		{[]string{"arch", "=", ""}, nil, []string{"'arm64'", "'x86'"}},
+		{[]string{"family", "=", "we"}, nil, nil},
+	}
+
+	for _, f := range fixture {
+		var got []string
+		for _, s := range Complete(f.words, f.quoted, names) {
+			got = append(got, s.Text)
+		}
+		if !reflect.DeepEqual(got, f.want) {
+			t.Errorf("%q: expected %v, got %v", f.words, f.want, got)
+		}
+	}
+}
@@ -10,14 +10,46 @@ import (
 	"github.com/alecthomas/participle/lexer/ebnf"
 )
 
+// Help documents filter expressions for the help command
+const Help = `Filter expressions select pods and machine types in pods_show and machines_show.
+
+A comparison compares a field with a value:
+
+  name = 'web-0'      name equals the quoted string
+  name != 'web-0'     name differs from the quoted string
+  name ~= 'web-.*'    name matches the regular expression
+  cpu >= 8            CPU in vCPU, compared with = != < <= > >=
+  mem < 64            memory in GB whatever the units setting, compared with = != < <= > >=
+
+Machine types have further fields:
+
+  family = 'n2'       machine family, compared like name
+  arch = 'arm64'      CPU architecture, x86 or arm64, compared like name
+  gen >= 2            generation of the machine family
+  net >= 10           network bandwidth in Gbps
+  disks >= 64         maximum number of persistent disks
+  shared = false      whether the machine type has a shared CPU, compared with = !=
+  localssd = true     whether local SSDs can be attached
+  deprecated = false  whether the machine type is deprecated
+
+Numbers are whole numbers. Comparisons are combined with & (and) and | (or), & binds
+stronger than |. Parentheses group comparisons:
+
+  cpu >= 8 & mem < 64
+  name ~= 'n2-.*' | (cpu = 4 & mem >= 16)
+  arch = 'x86' & shared = false & gen >= 2
+`
+
 const lexerSpec = `
 Whitespace = " " | "\t" | "\n" | "\r" .
 Natural = digit { digit } .
 NumericOp = ("<" ["="]) | (">" ["="]) .
 StringOp = "~=" .
 Op = ("!" "=") | "=" .
-NumericField = "mem" | "cpu" .
-StringField = "name" .
+NumericField = "mem" | "cpu" | "net" | "gen" | "disks" .
+StringField = "name" | "family" | "arch" .
+BoolField = "shared" | "localssd" | "deprecated" .
+Bool = "true" | "false" .
 Punct = "(" | ")" | "&" | "|" .
 QuotedString = "'" { "\u0000"…"\uffff"-"'" } "'"  .
 
@@ -32,17 +64,25 @@ type stringComparison struct {
 }
 
 func (cf *stringComparison) Pass(r types.Resource) bool {
+	v := r.Name
+	switch cf.Field {
+	case "family":
+		v = r.Machine.Family
+	case "arch":
+		v = r.Machine.Arch
+	}
+
 	switch cf.Op {
 	case "=":
-		return r.Name == cf.Value
+		return v == cf.Value
 	case "!=":
-		return r.Name != cf.Value
+		return v != cf.Value
 	case "~=":
 		rgx, err := regexp.Compile(cf.Value)
 		if err != nil {
 			return false
 		}
-		return rgx.MatchString(r.Name)
+		return rgx.MatchString(v)
 	}
 	return true
 }
@@ -54,42 +94,78 @@ type numericComparison struct {
 }
 
 func (cf *numericComparison) Pass(r types.Resource) bool {
-	if cf.Field != "mem" && cf.Field != "cpu" {
-		fmt.Println("failed to parse machine filter: expected mem or cpu")
-		return false
-	}
-	v := r.CPU
-	if cf.Field == "mem" {
+	// CPU, memory and network bandwidth are stored in thousandths of the unit they're compared in
+	var v int64
+	n := cf.Natural * 1000
+	switch cf.Field {
+	case "cpu":
+		v = r.CPU
+	case "mem":
 		v = r.Memory
+	case "net":
+		v = r.Machine.NetworkMbps
+	case "gen":
+		v, n = int64(r.Machine.Generation), cf.Natural
+	case "disks":
+		v, n = int64(r.Machine.MaxPersistentDisks), cf.Natural
+	default:
+		fmt.Println("failed to parse machine filter: unknown field", cf.Field)
+		return false
 	}
 
 	switch cf.Op {
 	case "=":
-		return v == cf.Natural*1000
+		return v == n
 	case "!=":
-		return v != cf.Natural*1000
+		return v != n
 	case ">":
-		return v > cf.Natural*1000
+		return v > n
 	case ">=":
-		return v >= cf.Natural*1000
+		return v >= n
 	case "<":
-		return v < cf.Natural*1000
+		return v < n
 	case "<=":
-		return v <= cf.Natural*1000
+		return v <= n
 	}
 	fmt.Println("failed to parse machine filter: unknown operator", cf.Op)
 	return false
 }
 
+type boolComparison struct {
+	Field string `@BoolField`
+	Op    string `@Op`
+	Value string `@Bool`
+}
+
+func (cf *boolComparison) Pass(r types.Resource) bool {
+	var v bool
+	switch cf.Field {
+	case "shared":
+		v = r.Machine.SharedCPU
+	case "localssd":
+		v = r.Machine.LocalSSD
+	case "deprecated":
+		v = r.Machine.Deprecated != ""
+	}
+	if cf.Op == "!=" {
+		return v != (cf.Value == "true")
+	}
+	return v == (cf.Value == "true")
+}
+
 type comparison struct {
 	NumericComp *numericComparison `@@`
 	StringComp  *stringComparison  `| @@`
+	BoolComp    *boolComparison    `| @@`
 }
 
 func (cf *comparison) Pass(r types.Resource) bool {
 	if cf.NumericComp != nil {
 		return cf.NumericComp.Pass(r)
 	}
+	if cf.BoolComp != nil {
+		return cf.BoolComp.Pass(r)
+	}
 	return cf.StringComp.Pass(r)
 }
 
@@ -159,16 +235,35 @@ func (cf *expression) Pass(r types.Resource) bool {
 	return false
 }
 
-func Create(args []string) (types.ResourceFilter, error) {
+// Create builds the filter of the expression made of args. quoted tells which of the args are
+// values whose quotes the REPL removed, it is nil if none are.
+func Create(args []string, quoted []bool) (types.ResourceFilter, error) {
 	if len(args) == 0 {
 		return &types.NoopResourceFilter{}, nil
 	}
 
-	expr := strings.Join(args, " ")
-
+	expr, err := joinArgs(args, quoted)
+	if err != nil {
+		return nil, err
+	}
 	return parseMachineFilter(expr)
 }
 
+// joinArgs joins the args of an expression, quoting the values among them again
+func joinArgs(args []string, quoted []bool) (string, error) {
+	parts := make([]string, 0, len(args))
+	for i, arg := range args {
+		if quoted != nil && quoted[i] {
+			if strings.Contains(arg, "'") {
+				return "", fmt.Errorf("value %s contains a '", arg)
+			}
+			arg = "'" + arg + "'"
+		}
+		parts = append(parts, arg)
+	}
+	return strings.Join(parts, " "), nil
+}
+
 func parseMachineFilter(expr string) (*expression, error) {
 	filterLexer, err := ebnf.New(lexerSpec)
 	if err != nil {
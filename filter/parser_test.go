@@ -1,6 +1,10 @@
 package filter
 
-import "testing"
+import (
+	"testing"
+
+	"nodepacker/types"
+)
 
 func TestMachineFilter(t *testing.T) {
 	fixture := []string{
@@ -14,6 +18,9 @@ func TestMachineFilter(t *testing.T) {
 		"name = 'foo' & cpu < 10",
 		"name != 'foo' & cpu < 10",
 		"name ~= 'foo' & cpu < 10",
+		"family = 'n2' & arch != 'arm64'",
+		"gen >= 2 & net > 10 & disks = 128",
+		"shared = false & localssd != true | deprecated = true",
 	}
 
 	for _, expr := range fixture {
@@ -23,3 +30,85 @@ func TestMachineFilter(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateQuotesValues(t *testing.T) {
+	fixture := []struct {
+		args   []string
+		quoted []bool
+	}{
+		{[]string{"name", "=", "indexed search"}, []bool{false, false, true}},
+		{[]string{"name", "~=", "web-.*", "&", "cpu", "<", "10"}, []bool{false, false, true, false, false, false, false}},
+		{[]string{"name = 'foo'", "&", "cpu < 10"}, nil},
+	}
+
+	for _, f := range fixture {
+		_, err := Create(f.args, f.quoted)
+		if err != nil {
+			t.Error(f.args, err)
+		}
+	}
+
+	if _, err := Create([]string{"name", "=", "it's"}, []bool{false, false, true}); err == nil {
+		t.Error("expected a value with a quote to fail")
+	}
+}
+
+func TestCreateQuotedFieldsAndNumbers(t *testing.T) {
+	m := types.Resource{Name: "cpu", CPU: 8000, Memory: 8000, Machine: types.MachineInfo{Family: "mem"}}
+
+	fixture := []struct {
+		args   []string
+		quoted []bool
+		pass   bool
+	}{
+		{[]string{"name", "=", "cpu"}, []bool{false, false, true}, true},
+		{[]string{"name", "=", "8"}, []bool{false, false, true}, false},
+		{[]string{"family", "=", "mem"}, []bool{false, false, true}, true},
+		{[]string{"cpu", "=", "8", "&", "name", "!=", "8"}, []bool{false, false, false, false, false, false, true}, true},
+	}
+
+	for _, f := range fixture {
+		rf, err := Create(f.args, f.quoted)
+		if err != nil {
+			t.Error(f.args, err)
+			continue
+		}
+		if rf.Pass(m) != f.pass {
+			t.Errorf("%q: expected %v", f.args, f.pass)
+		}
+	}
+
+	if _, err := Create([]string{"cpu", "=", "8"}, []bool{false, false, true}); err == nil {
+		t.Error("expected a quoted number in a numeric comparison to fail")
+	}
+}
+
+func TestMachineFilterFields(t *testing.T) {
+	m := types.Resource{Name: "n2d-standard-8", CPU: 8000, Memory: 32000, Machine: types.MachineInfo{
+		Family: "n2d", Generation: 2, Arch: types.ArchX86, LocalSSD: true, MaxPersistentDisks: 128,
+		NetworkMbps: 16000,
+	}}
+
+	fixture := []struct {
+		expr string
+		pass bool
+	}{
+		{"family = 'n2d'", true},
+		{"family ~= 'n2.*' & arch = 'arm64'", false},
+		{"gen >= 2 & disks = 128", true},
+		{"net > 16", false},
+		{"net >= 16", true},
+		{"shared = false & localssd = true", true},
+		{"deprecated != false", false},
+	}
+
+	for _, f := range fixture {
+		rf, err := parseMachineFilter(f.expr)
+		if err != nil {
+			t.Fatal(f.expr, err)
+		}
+		if rf.Pass(m) != f.pass {
+			t.Errorf("%s: expected %v", f.expr, f.pass)
+		}
+	}
+}
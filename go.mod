@@ -1,6 +1,6 @@
 module nodepacker
 
-go 1.15
+go 1.16
 
 require (
 	github.com/alecthomas/participle v0.6.0
@@ -0,0 +1,189 @@
+package nodepacker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"nodepacker/filter"
+)
+
+// commandSpec is the metadata of a command that help and completion are generated from
+type commandSpec struct {
+	name        string
+	description string
+	// synopsis of the arguments, e.g. "<pod> <node>"
+	synopsis string
+	examples []string
+	args     []argument
+	flagSet  FlagSetFn
+	// completers of flag values by flag name
+	flagValues map[string]ArgsCompleter
+	// whether the arguments after the flags are a filter expression
+	filter bool
+	// completes the names in name comparisons of the filter expression
+	filterNames ArgsCompleter
+	// the statement of an alias, empty for other commands
+	alias string
+}
+
+// usage sets the synopsis of the arguments of the command
+func (cs *commandSpec) usage(synopsis string) *commandSpec {
+	cs.synopsis = synopsis
+	return cs
+}
+
+// flags sets the flags of the command, they become the flags of its command line subcommand
+func (cs *commandSpec) flags(fsf FlagSetFn) *commandSpec {
+	cs.flagSet = fsf
+	return cs
+}
+
+// flagComplete sets the completer of the values of a flag
+func (cs *commandSpec) flagComplete(name string, ac ArgsCompleter) *commandSpec {
+	if cs.flagValues == nil {
+		cs.flagValues = make(map[string]ArgsCompleter)
+	}
+	cs.flagValues[name] = ac
+	return cs
+}
+
+// example adds example statements
+func (cs *commandSpec) example(statements ...string) *commandSpec {
+	cs.examples = append(cs.examples, statements...)
+	return cs
+}
+
+// filtered marks the arguments after the flags as a filter expression, see help filter. The names
+// completer completes the values of name comparisons.
+func (cs *commandSpec) filtered(names ArgsCompleter) *commandSpec {
+	cs.filter = true
+	cs.filterNames = names
+	return cs
+}
+
+// areas group the commands in help by the prefix of their name, commands with other prefixes are
+// listed as general commands
+var areas = []string{"machines", "manifests", "pods", "nodes", "prices", "scenario", "session"}
+
+const (
+	generalArea = "general"
+	aliasArea   = "aliases"
+)
+
+func areaOf(name string) string {
+	prefix := strings.SplitN(name, "_", 2)[0]
+	for _, area := range areas {
+		if prefix == area && prefix != name {
+			return area
+		}
+	}
+	return generalArea
+}
+
+// argument is a positional argument of a command, the completer completes it and help shows
+// the description
+type argument struct {
+	complete    ArgsCompleter
+	description string
+}
+
+var (
+	pathArg     = argument{pathComplete, "file and directory paths"}
+	machineArg  = argument{machineComplete, "machine types of the current zone"}
+	zoneArg     = argument{zoneComplete, "zones"}
+	podArg      = argument{podComplete, "pods"}
+	nodeArg     = argument{nodeComplete, "nodes of the cluster"}
+	scenarioArg = argument{scenarioComplete, "scenarios"}
+	outputArg   = argument{outputComplete, "output formats"}
+	commandArg  = argument{commandComplete, "commands"}
+	aliasArg    = argument{aliasComplete, "aliases"}
+)
+
+// valuesArg returns an argument that is one of the values
+func valuesArg(values ...string) argument {
+	return argument{valuesComplete(values...), "one of " + strings.Join(values, ", ")}
+}
+
+func helpCommand(cctx *CommandContext, args []string) error {
+	switch {
+	case len(args) == 0:
+		return printCommandList(cctx)
+	case len(args) == 1 && args[0] == "filter":
+		cctx.printf("%s", filter.Help)
+		return nil
+	case len(args) == 1:
+		cs, ok := cctx.specs[args[0]]
+		if !ok {
+			return notFoundErrorf("unknown command %s, help lists the commands", args[0])
+		}
+		return printCommandHelp(cctx, cs)
+	}
+	return argsErrorf("expected a command or filter")
+}
+
+func printCommandList(cctx *CommandContext) error {
+	names := make([]string, 0, len(cctx.specs))
+	for name := range cctx.specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	byArea := make(map[string][]*commandSpec)
+	for _, name := range names {
+		cs := cctx.specs[name]
+		area := areaOf(name)
+		if cs.alias != "" {
+			area = aliasArea
+		}
+		byArea[area] = append(byArea[area], cs)
+	}
+
+	w := tabwriter.NewWriter(cctx.out, 0, 0, 3, ' ', 0)
+	for _, area := range append(append([]string{generalArea}, areas...), aliasArea) {
+		if len(byArea[area]) == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%s:\n", area)
+		for _, cs := range byArea[area] {
+			_, _ = fmt.Fprintf(w, "  %s\t%s\n", cs.name, cs.description)
+		}
+		_, _ = fmt.Fprintln(w)
+	}
+	_, _ = fmt.Fprintln(w, "help <command> shows the usage of a command, help filter the filter expressions")
+	return w.Flush()
+}
+
+func printCommandHelp(cctx *CommandContext, cs *commandSpec) error {
+	cctx.printf("usage: %s\n\n%s\n", strings.TrimSpace(cs.name+" "+cs.synopsis), cs.description)
+
+	if cs.flagSet != nil {
+		cctx.printf("\nflags:\n")
+		fs := cs.flagSet()
+		fs.SetOutput(cctx.out)
+		fs.PrintDefaults()
+	}
+
+	var completions []string
+	for i, arg := range cs.args {
+		if arg.description != "" {
+			completions = append(completions, fmt.Sprintf("  argument %d: %s", i+1, arg.description))
+		}
+	}
+	if len(completions) > 0 {
+		cctx.printf("\ncompletion:\n%s\n", strings.Join(completions, "\n"))
+	}
+
+	if cs.filter {
+		cctx.printf("\nthe arguments after the flags are a filter expression, see help filter\n")
+	}
+
+	if len(cs.examples) > 0 {
+		cctx.printf("\nexamples:\n")
+		for _, e := range cs.examples {
+			cctx.printf("  %s\n", e)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package nodepacker
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/c-bata/go-prompt"
+)
+
+// default number of statements kept in the history file
+const DefaultHistorySize = 1000
+
+// statementHistory holds the statements executed in the REPL, persisted in a file
+type statementHistory struct {
+	path       string
+	size       int
+	statements []string
+
+	// state of the reverse search: the searched text and the index of the last match
+	query string
+	match int
+}
+
+func historyPath() (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(usr.HomeDir, ".nodepacker", "history"), nil
+}
+
+// loadStatementHistory reads the last size statements of the history file, a missing file is an
+// empty history. If the file holds more statements it is truncated.
+func loadStatementHistory(path string, size int) (*statementHistory, error) {
+	sh := &statementHistory{path: path, size: size}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return sh, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(lines) <= size {
+		sh.statements = lines
+		return sh, nil
+	}
+	sh.statements = lines[len(lines)-size:]
+	return sh, sh.rewrite()
+}
+
+// rewrite writes the statements to the history file, replacing it
+func (sh *statementHistory) rewrite() error {
+	tmp := sh.path + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(strings.Join(sh.statements, "\n")+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, sh.path)
+}
+
+// add appends a statement to the history and the history file
+func (sh *statementHistory) add(statement string) error {
+	sh.statements = append(sh.statements, statement)
+	sh.query = ""
+	if sh.size <= 0 {
+		return nil
+	}
+	if len(sh.statements) > sh.size {
+		sh.statements = sh.statements[len(sh.statements)-sh.size:]
+	}
+
+	err := os.MkdirAll(filepath.Dir(sh.path), 0777)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(sh.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(statement + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// expand replaces a history reference with the statement it refers to: !! is the last
+// statement, !n the n-th and !-n the n-th last statement as listed by the history command
+func (sh *statementHistory) expand(statement string) (string, bool, error) {
+	ref := strings.TrimSpace(statement)
+	if !strings.HasPrefix(ref, "!") {
+		return statement, false, nil
+	}
+
+	ref = ref[1:]
+	if ref == "!" {
+		ref = "-1"
+	}
+	n, err := strconv.Atoi(ref)
+	if err != nil || n == 0 {
+		return "", false, argsErrorf("expected !!, !<n> or !-<n>, got %s", statement)
+	}
+	if n < 0 {
+		n = len(sh.statements) + n + 1
+	}
+	if n < 1 || n > len(sh.statements) {
+		return "", false, notFoundErrorf("no statement %s in history", statement)
+	}
+	return sh.statements[n-1], true, nil
+}
+
+// search returns the newest statement containing query that is older than the statement
+// returned by the last search for the same query
+func (sh *statementHistory) search(query string) (string, bool) {
+	start := len(sh.statements) - 1
+	if query == sh.query {
+		start = sh.match - 1
+	}
+	for i := start; i >= 0; i-- {
+		if strings.Contains(sh.statements[i], query) {
+			sh.query = query
+			sh.match = i
+			return sh.statements[i], true
+		}
+	}
+	return "", false
+}
+
+// EnableHistory loads the statement history from ~/.nodepacker/history, keeping up to size
+// statements, and records the statements executed by ExecuteStatement from now on. It returns
+// the loaded statements for go-prompt's OptionHistory.
+func (crh *CommandReplHandler) EnableHistory(size int) ([]string, error) {
+	path, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+	sh, err := loadStatementHistory(path, size)
+	if err != nil {
+		return nil, err
+	}
+	crh.cctx.statements = sh
+	return append([]string(nil), sh.statements...), nil
+}
+
+// ReverseSearch is a go-prompt key binding that replaces the input with the newest statement
+// containing the input. Pressing it again goes on to older statements.
+func (crh *CommandReplHandler) ReverseSearch(buf *prompt.Buffer) {
+	sh := crh.cctx.statements
+	if sh == nil {
+		return
+	}
+
+	// when cycling, the input is the last match and the query is kept
+	query := buf.Text()
+	if sh.query != "" && sh.match < len(sh.statements) && sh.statements[sh.match] == query {
+		query = sh.query
+	}
+	statement, ok := sh.search(query)
+	if !ok {
+		return
+	}
+	buf.DeleteBeforeCursor(len([]rune(buf.Document().TextBeforeCursor())))
+	buf.Delete(len([]rune(buf.Document().TextAfterCursor())))
+	buf.InsertText(statement, false, true)
+}
+
+// history [n] lists the last n or all statements of the history
+func historyCommand(cctx *CommandContext, args []string) error {
+	sh := cctx.statements
+	if sh == nil {
+		return missingErrorf("history is only recorded in the REPL")
+	}
+
+	first := 0
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return argsErrorf("expected a positive number of statements, got %s", args[0])
+		}
+		if n < len(sh.statements) {
+			first = len(sh.statements) - n
+		}
+	} else if len(args) > 1 {
+		return argsErrorf("expected an optional number of statements")
+	}
+
+	for i := first; i < len(sh.statements); i++ {
+		cctx.printf("%5d  %s\n", i+1, sh.statements[i])
+	}
+	return nil
+}
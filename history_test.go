@@ -0,0 +1,74 @@
+package nodepacker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStatementHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodepacker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "history")
+	err = ioutil.WriteFile(path, []byte("pods_show\nnodes_show\n\nnodes_pack\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sh, err := loadStatementHistory(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"nodes_show", "nodes_pack"}; !reflect.DeepEqual(sh.statements, want) {
+		t.Errorf("expected %v, got %v", want, sh.statements)
+	}
+
+	err = sh.add("pods_show -o csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh, err = loadStatementHistory(path, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"nodes_show", "nodes_pack", "pods_show -o csv"}
+	if !reflect.DeepEqual(sh.statements, want) {
+		t.Errorf("expected %v after reload, got %v", want, sh.statements)
+	}
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"!1", "nodes_show"},
+		{"!!", "pods_show -o csv"},
+		{"!-2", "nodes_pack"},
+		{"!4", ""},
+		{"!x", ""},
+	}
+	for _, test := range tests {
+		got, ok, err := sh.expand(test.ref)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", test.ref, got)
+			}
+			continue
+		}
+		if err != nil || !ok || got != test.want {
+			t.Errorf("%s: expected %q, got %q, %v", test.ref, test.want, got, err)
+		}
+	}
+
+	if got, ok := sh.search("show"); !ok || got != "pods_show -o csv" {
+		t.Errorf("expected the newest match, got %q", got)
+	}
+	if got, ok := sh.search("show"); !ok || got != "nodes_show" {
+		t.Errorf("expected the next older match, got %q", got)
+	}
+}
@@ -2,103 +2,133 @@ package nodepacker
 
 import (
 	"bufio"
-	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"math"
 	"os"
 	"os/exec"
-	"os/user"
+	"path"
 	"path/filepath"
-	"strconv"
-	"strings"
 	"text/tabwriter"
 	"time"
 
 	"nodepacker/filter"
 	"nodepacker/types"
-	"github.com/dustin/go-humanize"
 	"gopkg.in/yaml.v3"
 )
 
-// Returns a map with keys zones and values a map with keys machine name and value Resource
-func availableMachines() (types.Machines, error) {
+// commandRunner runs a command and returns its standard output, tests replace it with a fake
+type commandRunner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+// execCommand runs a command, its standard error goes to the terminal
+func execCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
+
+// gcloudMachineType is a machine type as gcloud compute machine-types list --format=json lists it
+type gcloudMachineType struct {
+	Name                   string `json:"name"`
+	Zone                   string `json:"zone"`
+	GuestCPUs              int64  `json:"guestCpus"`
+	MemoryMB               int64  `json:"memoryMb"`
+	IsSharedCPU            bool   `json:"isSharedCpu"`
+	MaximumPersistentDisks int    `json:"maximumPersistentDisks"`
+	Deprecated             *struct {
+		State       string `json:"state"`
+		Replacement string `json:"replacement"`
+	} `json:"deprecated"`
+}
+
+// resource converts a machine type. gcloud lists memory in MiB, nodepacker counts a GiB as
+// 1000 MB like the gcloud table output does.
+func (mt gcloudMachineType) resource() types.Resource {
+	r := types.Resource{
+		Name:   mt.Name,
+		CPU:    mt.GuestCPUs * 1000,
+		Memory: int64(math.Round(float64(mt.MemoryMB) * 1000 / 1024)),
+		Machine: types.MachineInfo{
+			SharedCPU:          mt.IsSharedCPU,
+			MaxPersistentDisks: mt.MaximumPersistentDisks,
+		},
+	}
+	if mt.Deprecated != nil {
+		r.Machine.Deprecated = mt.Deprecated.State
+		r.Machine.Replacement = path.Base(mt.Deprecated.Replacement)
+	}
+	return r
+}
+
+// availableMachines lists the machine types of all zones with gcloud. Returns a map with keys zones
+// and values a map with keys machine name and value Resource
+func availableMachines(run commandRunner) (types.Machines, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "gcloud", "compute", "machine-types", "list")
-	cmd.Stderr = os.Stderr
-	var buf bytes.Buffer
-	cmd.Stdout = &buf
-	err := cmd.Run()
+	out, err := run(ctx, "gcloud", "compute", "machine-types", "list", "--format=json")
 	if err != nil {
 		return nil, err
 	}
 
-	zones := make(types.Machines)
-
-	scanner := bufio.NewScanner(&buf)
-	for scanner.Scan() {
-		parts := strings.Fields(scanner.Text())
+	var mts []gcloudMachineType
+	err = json.Unmarshal(out, &mts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the gcloud output: %v", err)
+	}
 
-		if len(parts) < 4 {
-			continue
-		}
-		if parts[0] == "NAME" {
+	zones := make(types.Machines)
+	for _, mt := range mts {
+		if mt.Name == "" || mt.Zone == "" {
 			continue
 		}
-
-		machines := zones[parts[1]]
+		// the zone may be given as a URL
+		zone := path.Base(mt.Zone)
+		machines := zones[zone]
 		if machines == nil {
 			machines = make(map[string]types.Resource)
-			zones[parts[1]] = machines
-		}
-		machines[parts[0]] = types.Resource{
-			Name:   parts[0],
-			Memory: parseMachineMem(parts[3]),
-			CPU:    parseMachineCPU(parts[2]),
+			zones[zone] = machines
 		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, err
+		machines[mt.Name] = mt.resource()
 	}
 	return zones, nil
 }
 
-func parseMachineMem(s string) int64 {
-	v, err := strconv.ParseFloat(s, 64)
+func fetchMachinesCommand(cctx *CommandContext, args []string) error {
+	machines, err := cctx.provider.Machines()
 	if err != nil {
-		return 0
+		return fmt.Errorf("error getting available machines: %w", err)
 	}
-	v = v * 1000
-	return int64(v)
-}
+	describeMachines(cctx.provider, machines)
 
-func parseMachineCPU(s string) int64 {
-	v, err := strconv.ParseInt(s, 10, 64)
+	cctx.machines = machines
+	cctx.infoln("got the machines")
+	err = saveMachines(cctx.config.CacheDir, cctx.provider.Name(), machines)
 	if err != nil {
-		return 0
+		cctx.infoln("warning: failed to save machines in", cctx.config.CacheDir+":", err)
 	}
-	v = v * 1000
-	return v
+	return nil
 }
 
-func fetchMachinesCommand(cctx *CommandContext, args []string) {
-	machines, err := availableMachines()
-	if err != nil {
-		fmt.Println("error getting available machines: ", err)
-	}
+// machineSortOrders are the sort orders of machines_show
+var machineSortOrders = []string{"cpu", "mem", "price"}
 
-	cctx.machines = machines
-	fmt.Println("got the machines")
-	err = saveMachines(machines)
-	if err != nil {
-		fmt.Println("failed to save machines in ~/.nodepacker/machines:", err)
-	}
+// showMachinesOptions are the flags of machines_show
+type showMachinesOptions struct {
+	sort   string
+	output string
 }
 
-func showMachinesCommand(cctx *CommandContext, args []string) {
+func (o *showMachinesOptions) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("machines_show", flag.ContinueOnError)
+	fs.StringVar(&o.sort, "sort", "cpu", "sort order: cpu, mem or price")
+	outputFlag(fs, &o.output)
+	return fs
+}
+
+func showMachinesCommand(cctx *CommandContext, args []string) error {
 	ms := cctx.machines[cctx.zone]
 
 	cpuSorter := func(a types.Resource, b types.Resource) bool {
@@ -121,54 +151,95 @@ func showMachinesCommand(cctx *CommandContext, args []string) {
 		return false
 	}
 
-	fs := flag.NewFlagSet("showMachinesCommand", flag.ContinueOnError)
-	sortOrder := fs.String("sort", "cpu", "sort order")
+	zp := cctx.prices[cctx.zone]
 
-	err := fs.Parse(args)
+	priceSorter := func(a types.Resource, b types.Resource) bool {
+		pa, aok := zp.Machines[a.Name]
+		pb, bok := zp.Machines[b.Name]
+		if aok != bok {
+			return aok
+		}
+		if pa.OnDemand != pb.OnDemand {
+			return pa.OnDemand < pb.OnDemand
+		}
+		return cpuSorter(a, b)
+	}
+
+	var o showMachinesOptions
+	fs := o.flagSet()
+
+	err := parseFlags(cctx, fs, args)
+	if err != nil {
+		return err
+	}
+	format, err := cctx.outputFormat(o.output)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
 
 	sorter := cpuSorter
-	if *sortOrder == "mem" {
+	switch o.sort {
+	case "mem":
 		sorter = memSorter
+	case "price":
+		sorter = priceSorter
 	}
 
 	sortedMs := types.SortResources(ms, sorter)
 
-	mf, err := filter.Create(fs.Args())
+	mf, err := filter.Create(fs.Args(), cctx.quotedArgs(fs.Args()))
 	if err != nil {
-		fmt.Println("failed to build filter", err)
-		mf = &types.NoopResourceFilter{}
+		return argsErrorf("failed to build filter: %v", err)
+	}
+
+	if format != outputTable {
+		var names []string
+		for _, k := range sortedMs {
+			if mf.Pass(ms[k]) {
+				names = append(names, k)
+			}
+		}
+		return writeMachines(cctx.out, format, newMachineRecords(cctx.zone, ms, zp, names))
 	}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.AlignRight)
+	w := tabwriter.NewWriter(cctx.out, 0, 0, 3, ' ', tabwriter.AlignRight)
 
 	for _, k := range sortedMs {
 		v := ms[k]
 
 		if mf.Pass(v) {
-			mem := humanize.Ftoa(float64(v.Memory) / 1000.0)
-			cpu := humanize.Ftoa(float64(v.CPU) / 1000.0)
-
-			_, _ = fmt.Fprintf(w, "%s\t%s\t%s GB\t\n", k, cpu, mem)
+			mem := types.FormatMachineMemory(v.Memory, cctx.config.Units) + " " + cctx.config.Units
+			cpu := types.FormatCPU(v.CPU)
+
+			p, ok := zp.Machines[k]
+			if !ok {
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t\n", k, cpu, mem)
+				continue
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t$%.4f/h\tspot $%.4f/h\t1y $%.4f/h\t3y $%.4f/h\t\n",
+				k, cpu, mem, p.OnDemand, p.Spot, p.Commit1Y, p.Commit3Y)
 		}
 	}
-	_ = w.Flush()
+	return w.Flush()
 }
 
-func saveMachines(machines types.Machines) error {
-	usr, err := user.Current()
-	if err != nil {
-		return err
+// machinesFile is the file the machines of a provider are cached in, machines.yaml for GCP and
+// machines-<provider>.yaml for the others
+func machinesFile(dir, provider string) string {
+	if provider == providers[0].Name() {
+		return filepath.Join(dir, "machines.yaml")
 	}
-	err = os.MkdirAll(filepath.Join(usr.HomeDir, ".nodepacker"), 0777)
+	return filepath.Join(dir, "machines-"+provider+".yaml")
+}
+
+// saveMachines saves the machines of a provider in the cache directory
+func saveMachines(dir, provider string, machines types.Machines) error {
+	err := os.MkdirAll(dir, 0777)
 	if err != nil {
 		return err
 	}
 
-	f, err := os.Create(filepath.Join(usr.HomeDir, ".nodepacker", "machines.yaml"))
+	f, err := os.Create(machinesFile(dir, provider))
 	if err != nil {
 		return err
 	}
@@ -181,13 +252,8 @@ func saveMachines(machines types.Machines) error {
 	return e.Encode(machines)
 }
 
-func readMachines() (types.Machines, error) {
-	usr, err := user.Current()
-	if err != nil {
-		return nil, err
-	}
-
-	f, err := os.Open(filepath.Join(usr.HomeDir, ".nodepacker", "machines.yaml"))
+func readMachines(dir, provider string) (types.Machines, error) {
+	f, err := os.Open(machinesFile(dir, provider))
 	if err != nil {
 		return nil, err
 	}
@@ -205,21 +271,20 @@ func readMachines() (types.Machines, error) {
 	return machines, nil
 }
 
-func getSetZoneCommand(cctx *CommandContext, args []string) {
+func getSetZoneCommand(cctx *CommandContext, args []string) error {
 	if len(args) == 0 {
-		fmt.Println(cctx.zone)
-		return
+		cctx.println(cctx.zone)
+		return nil
 	}
 	if len(args) == 1 {
 		_, ok := cctx.machines[args[0]]
 		if !ok {
-			fmt.Println("unknown zone")
-			return
+			return notFoundErrorf("unknown zone %s", args[0])
 		}
 		cctx.zone = args[0]
-		fmt.Println("set current zone to ", args[0])
-		return
+		cctx.infoln("set current zone to", args[0])
+		return nil
 	}
 
-	fmt.Println("expected no args to get current zone or one argument to set current zone")
+	return argsErrorf("expected no args to get current zone or one argument to set current zone")
 }
@@ -0,0 +1,124 @@
+package nodepacker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"nodepacker/types"
+)
+
+const gcloudMachineTypesJSON = `[
+  {
+    "guestCpus": 2,
+    "isSharedCpu": true,
+    "maximumPersistentDisks": 16,
+    "memoryMb": 1024,
+    "name": "e2-micro",
+    "zone": "us-central1-a"
+  },
+  {
+    "guestCpus": 4,
+    "isSharedCpu": false,
+    "maximumPersistentDisks": 128,
+    "memoryMb": 15360,
+    "name": "n1-standard-4",
+    "zone": "https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a"
+  },
+  {
+    "deprecated": {
+      "replacement": "https://www.googleapis.com/compute/v1/projects/p/zones/europe-west4-a/machineTypes/n2-standard-2",
+      "state": "DEPRECATED"
+    },
+    "guestCpus": 2,
+    "maximumPersistentDisks": 128,
+    "memoryMb": 7680,
+    "name": "n1-standard-2",
+    "zone": "europe-west4-a"
+  }
+]`
+
+// fakeGcloud returns a runner answering gcloud compute machine-types list with output
+func fakeGcloud(t *testing.T, output string) commandRunner {
+	return func(_ context.Context, name string, args ...string) ([]byte, error) {
+		cmd := name + " " + strings.Join(args, " ")
+		if cmd != "gcloud compute machine-types list --format=json" {
+			t.Errorf("unexpected command %s", cmd)
+			return nil, errors.New("unexpected command")
+		}
+		return []byte(output), nil
+	}
+}
+
+func TestAvailableMachines(t *testing.T) {
+	machines, err := availableMachines(fakeGcloud(t, gcloudMachineTypesJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := types.Machines{
+		"us-central1-a": {
+			"e2-micro": {Name: "e2-micro", CPU: 2000, Memory: 1000,
+				Machine: types.MachineInfo{SharedCPU: true, MaxPersistentDisks: 16}},
+			"n1-standard-4": {Name: "n1-standard-4", CPU: 4000, Memory: 15000,
+				Machine: types.MachineInfo{MaxPersistentDisks: 128}},
+		},
+		"europe-west4-a": {
+			"n1-standard-2": {Name: "n1-standard-2", CPU: 2000, Memory: 7500,
+				Machine: types.MachineInfo{MaxPersistentDisks: 128, Deprecated: "DEPRECATED", Replacement: "n2-standard-2"}},
+		},
+	}
+	if len(machines) != len(want) {
+		t.Fatalf("expected zones %v, got %v", want, machines)
+	}
+	for zone, ms := range want {
+		for name, m := range ms {
+			got := machines[zone][name]
+			if got.Name != m.Name || got.CPU != m.CPU || got.Memory != m.Memory || got.Machine != m.Machine {
+				t.Errorf("%s %s: expected %+v, got %+v", zone, name, m, got)
+			}
+		}
+	}
+
+	_, err = availableMachines(fakeGcloud(t, "NAME ZONE CPUS MEMORY_GB"))
+	if err == nil {
+		t.Error("expected table output to fail")
+	}
+}
+
+func TestFetchMachines(t *testing.T) {
+	crh := newTestHandler(t)
+	var out bytes.Buffer
+	crh.SetOutput(&out, &out)
+	crh.cctx.provider = gcpProvider{
+		builtinProvider: providers[0].(gcpProvider).builtinProvider,
+		run:             fakeGcloud(t, gcloudMachineTypesJSON),
+	}
+
+	err := crh.Execute("machines_fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	err = crh.Execute("machines_show -o csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "name,zone,cpuMillis,memoryMB,family,generation,arch,sharedCpu,localSsd,maxPersistentDisks," +
+		"networkMbps,deprecated,onDemand,spot,commit1y,commit3y\n" +
+		"e2-micro,us-central1-a,2000,1000,e2,2,x86,true,false,16,1000,,,,,\n" +
+		"n1-standard-4,us-central1-a,4000,15000,n1,1,x86,false,true,128,8000,,,,,\n"
+	if out.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out.String())
+	}
+
+	ms, err := readMachines(crh.cctx.config.CacheDir, "gcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := ms["europe-west4-a"]["n1-standard-2"]; m.Machine.Deprecated != "DEPRECATED" {
+		t.Errorf("expected the cached machines to keep the deprecation, got %+v", m)
+	}
+}
@@ -93,6 +93,36 @@ func extractName(res map[string]interface{}) (string, error) {
 	return name, nil
 }
 
+// extractMetadataMap returns the pod template labels or annotations (field is "labels" or "annotations"),
+// falling back to those of the workload itself
+func extractMetadataMap(res map[string]interface{}, field string) map[string]string {
+	values := make(map[string]string)
+
+	collect := func(meta interface{}) {
+		m, ok := meta.(map[string]interface{})
+		if !ok {
+			return
+		}
+		vs, ok := m[field].(map[string]interface{})
+		if !ok {
+			return
+		}
+		for k, v := range vs {
+			if s, ok := v.(string); ok {
+				values[k] = s
+			}
+		}
+	}
+
+	collect(res["metadata"])
+	if spec, ok := res["spec"].(map[string]interface{}); ok {
+		if template, ok := spec["template"].(map[string]interface{}); ok {
+			collect(template["metadata"])
+		}
+	}
+	return values
+}
+
 func extractNumReplicas(res map[string]interface{}) (int, error) {
 	spec, ok := res["spec"].(map[string]interface{})
 	if !ok {
@@ -137,6 +167,10 @@ func loadManifest(path string) ([]types.Resource, error) {
 			return nil, err
 		}
 		r.Name = name
+		r.Workload = name
+		r.Kind = kind
+		r.Labels = extractMetadataMap(res, "labels")
+		r.Annotations = extractMetadataMap(res, "annotations")
 		numReplicas, err := extractNumReplicas(res)
 		if err != nil {
 			return nil, err
@@ -148,10 +182,14 @@ func loadManifest(path string) ([]types.Resource, error) {
 		} else {
 			for i := 0; i < numReplicas; i++ {
 				rs = append(rs, types.Resource{
-					Memory:  r.Memory,
-					CPU:     r.CPU,
-					Storage: r.Storage,
-					Name:    fmt.Sprintf("%s-%d", r.Name, i),
+					Memory:      r.Memory,
+					CPU:         r.CPU,
+					Storage:     r.Storage,
+					Name:        fmt.Sprintf("%s-%d", r.Name, i),
+					Workload:    r.Workload,
+					Kind:        r.Kind,
+					Labels:      r.Labels,
+					Annotations: r.Annotations,
 				})
 			}
 		}
@@ -198,12 +236,17 @@ func loadManifests(inputs []string) (map[string]types.Resource, error) {
 	return podsByName, nil
 }
 
-func readManifestsCommand(cctx *CommandContext, args []string) {
+func readManifestsCommand(cctx *CommandContext, args []string) error {
+	if len(args) == 0 {
+		return argsErrorf("expected one or more manifest files or directories")
+	}
+
 	mfs, err := loadManifests(args)
 	if err != nil {
-		fmt.Printf("failed to load manifests from %v: %v", args, err)
+		return fmt.Errorf("failed to load manifests from %v: %w", args, err)
 	}
 
 	cctx.pods = mfs
-	fmt.Println("got the manifests")
+	cctx.infoln("got the manifests")
+	return nil
 }
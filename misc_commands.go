@@ -1,14 +1,34 @@
 package nodepacker
 
 import (
-	"fmt"
 	"os"
 )
 
-func helpCommand(cctx *CommandContext, args []string) {
-	fmt.Println("called help")
+func exitCommand(cctx *CommandContext, args []string) error {
+	os.Exit(0)
+	return nil
 }
 
-func exitCommand(cctx *CommandContext, args []string) {
-	os.Exit(0)
+func sourceCommand(cctx *CommandContext, args []string) error {
+	if len(args) != 1 {
+		return argsErrorf("expected a script file")
+	}
+
+	return cctx.executeFile(args[0])
+}
+
+// output [format]
+func outputCommand(cctx *CommandContext, args []string) error {
+	if len(args) == 0 {
+		format, _ := cctx.outputFormat("")
+		cctx.println("output format is", format)
+		return nil
+	}
+
+	err := validOutputFormat(args[0])
+	if err != nil {
+		return err
+	}
+	cctx.output = args[0]
+	return nil
 }
@@ -1,5 +1,268 @@
 package nodepacker
 
-func addNodesCommand(cctx *CommandContext, args []string) {
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
+	"nodepacker/types"
+)
+
+// clusterNode is a node of the cluster with the pods assigned to it and its remaining capacity
+type clusterNode struct {
+	name    string
+	machine types.Resource
+	labels  map[string]string
+	pods    []string
+	free    types.Resource
+}
+
+func newClusterNode(name string, machine types.Resource, labels map[string]string) *clusterNode {
+	return &clusterNode{
+		name:    name,
+		machine: machine,
+		labels:  labels,
+		free:    types.Resource{Memory: machine.Memory, CPU: machine.CPU},
+	}
+}
+
+func (n *clusterNode) fits(pod types.Resource) bool {
+	return n.free.CPU >= pod.CPU && n.free.Memory >= pod.Memory
+}
+
+func (n *clusterNode) assign(pod types.Resource) {
+	n.free = types.Resource{
+		Memory: n.free.Memory - pod.Memory,
+		CPU:    n.free.CPU - pod.CPU,
+	}
+	n.pods = append(n.pods, pod.Name)
+}
+
+func (n *clusterNode) remove(pod types.Resource) bool {
+	for i, name := range n.pods {
+		if name == pod.Name {
+			n.pods = append(n.pods[:i], n.pods[i+1:]...)
+			n.free = types.Resource{
+				Memory: n.free.Memory + pod.Memory,
+				CPU:    n.free.CPU + pod.CPU,
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// overcommitted returns by how much the pods of the node exceed its capacity, and false if they don't
+func (n *clusterNode) overcommitted() (types.Resource, bool) {
+	var over types.Resource
+	if n.free.CPU < 0 {
+		over.CPU = -n.free.CPU
+	}
+	if n.free.Memory < 0 {
+		over.Memory = -n.free.Memory
+	}
+	return over, over.CPU > 0 || over.Memory > 0
+}
+
+// nodeOf returns the node the pod is assigned to
+func nodeOf(nodes []*clusterNode, pod string) *clusterNode {
+	for _, node := range nodes {
+		for _, name := range node.pods {
+			if name == pod {
+				return node
+			}
+		}
+	}
+	return nil
+}
+
+// nodeIndex returns n for node names of the form <prefix>-<n>
+func nodeIndex(name, prefix string) (int, bool) {
+	if !strings.HasPrefix(name, prefix+"-") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(name, prefix+"-"))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// nextNodeIndex returns one more than the largest n of the node names <prefix>-<n> in use
+func nextNodeIndex(nodes []*clusterNode, prefix string) int {
+	next := 0
+	for _, node := range nodes {
+		if n, ok := nodeIndex(node.name, prefix); ok && n >= next {
+			next = n + 1
+		}
+	}
+	return next
+}
+
+// nextNodeName returns <prefix>-<n> with n one larger than the largest index in use
+func nextNodeName(nodes []*clusterNode, prefix string) string {
+	return fmt.Sprintf("%s-%d", prefix, nextNodeIndex(nodes, prefix))
+}
+
+// emptyNodes returns copies of the nodes without any pods assigned
+func emptyNodes(nodes []*clusterNode) []*clusterNode {
+	res := make([]*clusterNode, 0, len(nodes))
+	for _, node := range nodes {
+		res = append(res, newClusterNode(node.name, node.machine, copyLabels(node.labels)))
+	}
+	return res
+}
+
+func findNode(nodes []*clusterNode, name string) (int, *clusterNode) {
+	for i, node := range nodes {
+		if node.name == name {
+			return i, node
+		}
+	}
+	return -1, nil
+}
+
+// copyLabels returns a copy of labels, nodes don't share their labels
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	res := make(map[string]string, len(labels))
+	for k, v := range labels {
+		res[k] = v
+	}
+	return res
+}
+
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var kvs []string
+	for _, k := range keys {
+		kvs = append(kvs, k+"="+labels[k])
+	}
+	return strings.Join(kvs, ",")
+}
+
+// nodes_add <machineType> [count] [key=value...]
+func addNodesCommand(cctx *CommandContext, args []string) error {
+	if len(args) == 0 {
+		return argsErrorf("expected a machine type, an optional count and optional labels <key>=<value>")
+	}
+
+	machine, ok := cctx.machines[cctx.zone][args[0]]
+	cp, hasCustom := cctx.provider.(customMachineProvider)
+	if !ok && hasCustom && customMachineName.MatchString(args[0]) {
+		if _, _, _, err := parseCustomMachine(args[0]); err != nil {
+			return argsErrorf("%v", err)
+		}
+		machine, ok = cp.CustomMachine(args[0], cctx.machines[cctx.zone])
+	}
+	if !ok {
+		return notFoundErrorf("unknown machine type %s in zone %s", args[0], cctx.zone)
+	}
+
+	count := 1
+	labels := make(map[string]string)
+	for i, arg := range args[1:] {
+		if kv := strings.SplitN(arg, "=", 2); len(kv) == 2 {
+			labels[kv[0]] = kv[1]
+			continue
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil || i != 0 || n < 1 {
+			return argsErrorf("expected a positive count or a label <key>=<value>, got %s", arg)
+		}
+		count = n
+	}
+
+	for i := 0; i < count; i++ {
+		node := newClusterNode(nextNodeName(cctx.nodes, "node"), machine, copyLabels(labels))
+		cctx.nodes = append(cctx.nodes, node)
+		cctx.println("added", node.name, machine.FormatMachine(cctx.config.Units))
+	}
+	return nil
+}
+
+// nodes_remove <node>...
+func removeNodesCommand(cctx *CommandContext, args []string) error {
+	if len(args) == 0 {
+		return argsErrorf("expected one or more node names")
+	}
+
+	nodes := append([]*clusterNode(nil), cctx.nodes...)
+	for _, name := range args {
+		idx, node := findNode(nodes, name)
+		if node == nil {
+			return notFoundErrorf("unknown node %s", name)
+		}
+		nodes = append(nodes[:idx], nodes[idx+1:]...)
+		if len(node.pods) > 0 {
+			cctx.infof("pods of %s are no longer placed: [%s]\n", name, strings.Join(node.pods, ", "))
+		}
+	}
+
+	cctx.nodes = nodes
+	cctx.printf("cluster has %d nodes\n", len(nodes))
+	return nil
+}
+
+// showNodesOptions are the flags of nodes_show
+type showNodesOptions struct {
+	output string
+}
+
+func (o *showNodesOptions) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("nodes_show", flag.ContinueOnError)
+	outputFlag(fs, &o.output)
+	return fs
+}
+
+func showNodesCommand(cctx *CommandContext, args []string) error {
+	var o showNodesOptions
+	fs := o.flagSet()
+
+	err := parseFlags(cctx, fs, args)
+	if err != nil {
+		return err
+	}
+	format, err := cctx.outputFormat(o.output)
+	if err != nil {
+		return err
+	}
+
+	if format != outputTable {
+		return writeNodes(cctx.out, format, newNodeRecords(cctx.nodes))
+	}
+
+	if len(cctx.nodes) == 0 {
+		cctx.println("the cluster has no nodes. please execute command nodes_add or nodes_pack")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(cctx.out, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NODE\tMACHINE\tLABELS\tPODS\tFREE CPU\tFREE MEM\t")
+
+	unit := cctx.config.Units
+	var capacity, free types.Resource
+	for _, node := range cctx.nodes {
+		cpu := types.FormatCPU(node.free.CPU)
+		mem := types.FormatMachineMemory(node.free.Memory, unit)
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s %s\t\n", node.name, node.machine.Name,
+			formatLabels(node.labels), len(node.pods), cpu, mem, unit)
+
+		capacity = types.AddResources(capacity, node.machine)
+		free = types.AddResources(free, node.free)
+	}
+	_ = w.Flush()
+
+	cctx.printf("\ntotal %s, free %s\n", capacity.FormatMachine(unit), free.FormatMachine(unit))
+	return nil
 }
@@ -0,0 +1,133 @@
+package nodepacker
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"nodepacker/types"
+)
+
+// newClusterTestHandler returns a handler with a single machine type small in the current zone
+// that holds one of the pods a, b and c
+func newClusterTestHandler(t *testing.T) (*CommandReplHandler, *bytes.Buffer) {
+	crh := newTestHandler(t)
+	var out bytes.Buffer
+	crh.SetOutput(&out, &out)
+	crh.cctx.machines = types.Machines{
+		crh.cctx.zone: {"small": {Name: "small", CPU: 2000, Memory: 4000}},
+	}
+	crh.cctx.pods = map[string]types.Resource{
+		"a": {Name: "a", CPU: 1500, Memory: 3000},
+		"b": {Name: "b", CPU: 1500, Memory: 3000},
+		"c": {Name: "c", CPU: 1500, Memory: 3000},
+	}
+	return crh, &out
+}
+
+func nodeNames(nodes []*clusterNode) []string {
+	var names []string
+	for _, node := range nodes {
+		names = append(names, node.name)
+	}
+	return names
+}
+
+func TestAddRemoveNodes(t *testing.T) {
+	crh, _ := newClusterTestHandler(t)
+
+	err := crh.Execute("nodes_add small 2 pool=default; nodes_add small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeNames(crh.cctx.nodes); !reflect.DeepEqual(got, []string{"node-0", "node-1", "node-2"}) {
+		t.Fatalf("unexpected nodes %v", got)
+	}
+	if crh.cctx.nodes[1].labels["pool"] != "default" || len(crh.cctx.nodes[2].labels) != 0 {
+		t.Errorf("unexpected labels %v and %v", crh.cctx.nodes[1].labels, crh.cctx.nodes[2].labels)
+	}
+	crh.cctx.nodes[0].labels["pool"] = "spot"
+	if crh.cctx.nodes[1].labels["pool"] != "default" {
+		t.Errorf("expected the nodes not to share their labels, got %v", crh.cctx.nodes[1].labels)
+	}
+	crh.cctx.replState.clone().nodes[1].labels["pool"] = "spot"
+	emptyNodes(crh.cctx.nodes)[1].labels["pool"] = "spot"
+	if crh.cctx.nodes[1].labels["pool"] != "default" {
+		t.Errorf("expected copies of the nodes not to share the labels, got %v", crh.cctx.nodes[1].labels)
+	}
+
+	err = crh.Execute("nodes_remove node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = crh.Execute("nodes_add small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeNames(crh.cctx.nodes); !reflect.DeepEqual(got, []string{"node-0", "node-2", "node-3"}) {
+		t.Errorf("expected new nodes to get the next free index, got %v", got)
+	}
+
+	tests := []struct {
+		statement string
+		kind      error
+	}{
+		{"nodes_add", ErrInvalidArgs},
+		{"nodes_add small 0", ErrInvalidArgs},
+		{"nodes_add small 2 3", ErrInvalidArgs},
+		{"nodes_add large", ErrNotFound},
+		{"nodes_remove", ErrInvalidArgs},
+		{"nodes_remove node-0 node-9", ErrNotFound},
+	}
+	for _, test := range tests {
+		err := crh.Execute(test.statement)
+		if !errors.Is(err, test.kind) {
+			t.Errorf("%s: expected error kind %v, got %v", test.statement, test.kind, err)
+		}
+	}
+	if got := nodeNames(crh.cctx.nodes); len(got) != 3 {
+		t.Errorf("failed commands changed the nodes: %v", got)
+	}
+}
+
+func TestPackExisting(t *testing.T) {
+	crh, out := newClusterTestHandler(t)
+
+	err := crh.Execute("nodes_pack -existing")
+	if err == nil || !errors.Is(err, ErrMissingState) {
+		t.Errorf("expected -existing without nodes to fail, got %v", err)
+	}
+
+	err = crh.Execute("nodes_add small 2 pool=default; nodes_pack -existing -no-grow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "pods that could not be placed: [c]") {
+		t.Errorf("expected c to be unschedulable, got\n%s", out.String())
+	}
+	if got := nodeNames(crh.cctx.nodes); !reflect.DeepEqual(got, []string{"node-0", "node-1"}) {
+		t.Errorf("expected -no-grow to keep the nodes, got %v", got)
+	}
+	if crh.cctx.nodes[0].labels["pool"] != "default" {
+		t.Errorf("expected the nodes to keep their labels, got %v", crh.cctx.nodes[0].labels)
+	}
+
+	out.Reset()
+	err = crh.Execute("nodes_pack -existing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "could not be placed") {
+		t.Errorf("expected every pod to be placed, got\n%s", out.String())
+	}
+	if got := nodeNames(crh.cctx.nodes); !reflect.DeepEqual(got, []string{"node-0", "node-1", "node-2"}) {
+		t.Errorf("expected a node to be added, got %v", got)
+	}
+
+	err = crh.Execute("nodes_pack -existing -search")
+	if !errors.Is(err, ErrInvalidArgs) {
+		t.Errorf("expected -existing -search to fail with ErrInvalidArgs, got %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+package nodepacker
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"nodepacker/types"
+	"gopkg.in/yaml.v3"
+)
+
+// output formats of the list and plan commands
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputCSV   = "csv"
+)
+
+var outputFormats = []string{outputTable, outputJSON, outputYAML, outputCSV}
+
+func validOutputFormat(format string) error {
+	for _, f := range outputFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return argsErrorf("unknown output format %s, expected one of %s", format, strings.Join(outputFormats, ", "))
+}
+
+// outputFlag defines the -o flag of a command
+func outputFlag(fs *flag.FlagSet, output *string) {
+	fs.StringVar(output, "o", "", "output format: "+strings.Join(outputFormats, ", ")+" (default is set by the output command)")
+}
+
+// outputFormat returns the format a command writes its output in: the format of its -o flag or,
+// if not given, the default output format
+func (cctx *CommandContext) outputFormat(flagValue string) (string, error) {
+	if flagValue == "" {
+		flagValue = cctx.output
+	}
+	if flagValue == "" {
+		return outputTable, nil
+	}
+	return flagValue, validOutputFormat(flagValue)
+}
+
+// the records below are the stable schemas of the json, yaml and csv output. CPU is in
+// millicores, memory and storage in MB, prices in USD.
+
+type podRecord struct {
+	Name     string `json:"name" yaml:"name"`
+	Workload string `json:"workload,omitempty" yaml:"workload,omitempty"`
+	Kind     string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	CPU      int64  `json:"cpuMillis" yaml:"cpuMillis"`
+	Memory   int64  `json:"memoryMB" yaml:"memoryMB"`
+	Storage  int64  `json:"storageMB" yaml:"storageMB"`
+	Node     string `json:"node,omitempty" yaml:"node,omitempty"`
+	PinnedTo string `json:"pinnedTo,omitempty" yaml:"pinnedTo,omitempty"`
+}
+
+type priceRecord struct {
+	OnDemand float64 `json:"onDemand" yaml:"onDemand"`
+	Spot     float64 `json:"spot" yaml:"spot"`
+	Commit1Y float64 `json:"commit1y" yaml:"commit1y"`
+	Commit3Y float64 `json:"commit3y" yaml:"commit3y"`
+}
+
+type machineRecord struct {
+	Name   string `json:"name" yaml:"name"`
+	Zone   string `json:"zone" yaml:"zone"`
+	CPU    int64  `json:"cpuMillis" yaml:"cpuMillis"`
+	Memory int64  `json:"memoryMB" yaml:"memoryMB"`
+	// machine type attributes, if known. Network bandwidth is in Mbps.
+	Family             string `json:"family,omitempty" yaml:"family,omitempty"`
+	Generation         int    `json:"generation,omitempty" yaml:"generation,omitempty"`
+	Arch               string `json:"arch,omitempty" yaml:"arch,omitempty"`
+	SharedCPU          bool   `json:"sharedCpu,omitempty" yaml:"sharedCpu,omitempty"`
+	LocalSSD           bool   `json:"localSsd,omitempty" yaml:"localSsd,omitempty"`
+	MaxPersistentDisks int    `json:"maxPersistentDisks,omitempty" yaml:"maxPersistentDisks,omitempty"`
+	NetworkMbps        int64  `json:"networkMbps,omitempty" yaml:"networkMbps,omitempty"`
+	Deprecated         string `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
+	// hourly prices, if known
+	Prices *priceRecord `json:"prices,omitempty" yaml:"prices,omitempty"`
+}
+
+type freeRecord struct {
+	CPU    int64 `json:"cpuMillis" yaml:"cpuMillis"`
+	Memory int64 `json:"memoryMB" yaml:"memoryMB"`
+}
+
+type nodeRecord struct {
+	Name    string            `json:"name" yaml:"name"`
+	Machine string            `json:"machine" yaml:"machine"`
+	Labels  map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Pods    []string          `json:"pods" yaml:"pods"`
+	Free    freeRecord        `json:"free" yaml:"free"`
+}
+
+type costRecord struct {
+	Model   string  `json:"model" yaml:"model"`
+	Monthly float64 `json:"monthly" yaml:"monthly"`
+	Annual  float64 `json:"annual" yaml:"annual"`
+}
+
+type planRecord struct {
+	Machine       string       `json:"machine" yaml:"machine"`
+	Nodes         []nodeRecord `json:"nodes" yaml:"nodes"`
+	Unschedulable []string     `json:"unschedulable" yaml:"unschedulable"`
+	Warnings      []string     `json:"warnings" yaml:"warnings"`
+	Cost          *costRecord  `json:"cost,omitempty" yaml:"cost,omitempty"`
+}
+
+// poolRecord holds the ranked plans of a node pool, the first one is the plan nodes_pack uses
+type poolRecord struct {
+	Name  string       `json:"name" yaml:"name"`
+	Pods  int          `json:"pods" yaml:"pods"`
+	Plans []planRecord `json:"plans" yaml:"plans"`
+}
+
+type packRecord struct {
+	Pools []poolRecord `json:"pools" yaml:"pools"`
+	// with a spot pool, the plan putting all pods on on-demand nodes
+	Baseline *planRecord `json:"baseline,omitempty" yaml:"baseline,omitempty"`
+}
+
+func newPodRecords(cctx *CommandContext, names []string) []podRecord {
+	records := make([]podRecord, 0, len(names))
+	for _, name := range names {
+		pod := cctx.pods[name]
+		r := podRecord{
+			Name:     name,
+			Workload: pod.Workload,
+			Kind:     pod.Kind,
+			CPU:      pod.CPU,
+			Memory:   pod.Memory,
+			Storage:  pod.Storage,
+			PinnedTo: cctx.pins[name],
+		}
+		if node := nodeOf(cctx.nodes, name); node != nil {
+			r.Node = node.name
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func newMachineRecords(zone string, ms map[string]types.Resource, zp types.ZonePrices, names []string) []machineRecord {
+	records := make([]machineRecord, 0, len(names))
+	for _, name := range names {
+		m := ms[name]
+		r := machineRecord{
+			Name:               name,
+			Zone:               zone,
+			CPU:                m.CPU,
+			Memory:             m.Memory,
+			Family:             m.Machine.Family,
+			Generation:         m.Machine.Generation,
+			Arch:               m.Machine.Arch,
+			SharedCPU:          m.Machine.SharedCPU,
+			LocalSSD:           m.Machine.LocalSSD,
+			MaxPersistentDisks: m.Machine.MaxPersistentDisks,
+			NetworkMbps:        m.Machine.NetworkMbps,
+			Deprecated:         m.Machine.Deprecated,
+		}
+		if p, ok := zp.Machines[name]; ok {
+			r.Prices = &priceRecord{OnDemand: p.OnDemand, Spot: p.Spot, Commit1Y: p.Commit1Y, Commit3Y: p.Commit3Y}
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func newNodeRecords(nodes []*clusterNode) []nodeRecord {
+	records := make([]nodeRecord, 0, len(nodes))
+	for _, node := range nodes {
+		records = append(records, nodeRecord{
+			Name:    node.name,
+			Machine: node.machine.Name,
+			Labels:  node.labels,
+			Pods:    append([]string{}, node.pods...),
+			Free:    freeRecord{CPU: node.free.CPU, Memory: node.free.Memory},
+		})
+	}
+	return records
+}
+
+func newPlanRecord(plan *packPlan) planRecord {
+	r := planRecord{
+		Machine:       plan.machine.Name,
+		Nodes:         newNodeRecords(plan.nodes),
+		Unschedulable: append([]string{}, plan.unschedulable...),
+		Warnings:      append([]string{}, plan.warnings...),
+	}
+	if plan.cost != nil {
+		r.Cost = &costRecord{Model: plan.cost.model, Monthly: cents(plan.cost.monthly()), Annual: cents(plan.cost.annual())}
+	}
+	return r
+}
+
+func newPoolRecord(name string, pods int, plans []*packPlan, top int) poolRecord {
+	if top > 0 && len(plans) > top {
+		plans = plans[:top]
+	}
+	pr := poolRecord{Name: name, Pods: pods}
+	for _, plan := range plans {
+		pr.Plans = append(pr.Plans, newPlanRecord(plan))
+	}
+	return pr
+}
+
+// writeStructured writes v as json or yaml
+func writeStructured(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case outputJSON:
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(v)
+	case outputYAML:
+		e := yaml.NewEncoder(w)
+		defer e.Close()
+		return e.Encode(v)
+	}
+	return fmt.Errorf("output format %s is not supported here", format)
+}
+
+func writeCSV(w io.Writer, header []string, rows [][]string) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(header)
+	if err != nil {
+		return err
+	}
+	err = cw.WriteAll(rows)
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// cents rounds a dollar amount to cents
+func cents(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+func itoa(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func writePods(w io.Writer, format string, records []podRecord) error {
+	if format != outputCSV {
+		return writeStructured(w, format, records)
+	}
+	rows := make([][]string, 0, len(records))
+	for _, r := range records {
+		rows = append(rows, []string{r.Name, r.Workload, r.Kind, itoa(r.CPU), itoa(r.Memory), itoa(r.Storage),
+			r.Node, r.PinnedTo})
+	}
+	return writeCSV(w, []string{"name", "workload", "kind", "cpuMillis", "memoryMB", "storageMB", "node", "pinnedTo"},
+		rows)
+}
+
+func writeMachines(w io.Writer, format string, records []machineRecord) error {
+	if format != outputCSV {
+		return writeStructured(w, format, records)
+	}
+	rows := make([][]string, 0, len(records))
+	for _, r := range records {
+		row := []string{r.Name, r.Zone, itoa(r.CPU), itoa(r.Memory), r.Family, strconv.Itoa(r.Generation), r.Arch,
+			strconv.FormatBool(r.SharedCPU), strconv.FormatBool(r.LocalSSD), strconv.Itoa(r.MaxPersistentDisks),
+			itoa(r.NetworkMbps), r.Deprecated, "", "", "", ""}
+		if r.Prices != nil {
+			row[12] = ftoa(r.Prices.OnDemand)
+			row[13] = ftoa(r.Prices.Spot)
+			row[14] = ftoa(r.Prices.Commit1Y)
+			row[15] = ftoa(r.Prices.Commit3Y)
+		}
+		rows = append(rows, row)
+	}
+	return writeCSV(w, []string{"name", "zone", "cpuMillis", "memoryMB", "family", "generation", "arch", "sharedCpu",
+		"localSsd", "maxPersistentDisks", "networkMbps", "deprecated", "onDemand", "spot", "commit1y", "commit3y"}, rows)
+}
+
+var nodeCSVHeader = []string{"name", "machine", "labels", "pods", "freeCpuMillis", "freeMemoryMB"}
+
+func nodeCSVRow(r nodeRecord) []string {
+	return []string{r.Name, r.Machine, formatLabels(r.Labels), strings.Join(r.Pods, " "), itoa(r.Free.CPU),
+		itoa(r.Free.Memory)}
+}
+
+func writeNodes(w io.Writer, format string, records []nodeRecord) error {
+	if format != outputCSV {
+		return writeStructured(w, format, records)
+	}
+	rows := make([][]string, 0, len(records))
+	for _, r := range records {
+		rows = append(rows, nodeCSVRow(r))
+	}
+	return writeCSV(w, nodeCSVHeader, rows)
+}
+
+// writePack writes the plans of nodes_pack. The csv output has a row per node of each plan, the
+// unschedulable pods of a plan are in a row with an empty node name.
+func writePack(w io.Writer, format string, pr packRecord) error {
+	if format != outputCSV {
+		return writeStructured(w, format, pr)
+	}
+	var rows [][]string
+	for _, pool := range pr.Pools {
+		for i, plan := range pool.Plans {
+			prefix := []string{pool.Name, strconv.Itoa(i + 1)}
+			for _, node := range plan.Nodes {
+				rows = append(rows, append(append([]string{}, prefix...), nodeCSVRow(node)...))
+			}
+			if len(plan.Unschedulable) > 0 {
+				row := append(append([]string{}, prefix...), "", "", "", strings.Join(plan.Unschedulable, " "), "", "")
+				rows = append(rows, row)
+			}
+		}
+	}
+	return writeCSV(w, append([]string{"pool", "rank"}, nodeCSVHeader...), rows)
+}
+
+// sortedPodNames returns the names of the pods passing the filter, sorted
+func sortedPodNames(pods map[string]types.Resource, rf types.ResourceFilter) []string {
+	names := make([]string, 0, len(pods))
+	for k, v := range pods {
+		if rf.Pass(v) {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
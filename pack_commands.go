@@ -1,9 +1,16 @@
 package nodepacker
 
 import (
+	"flag"
 	"fmt"
 	"math"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
 
 	"nodepacker/types"
 )
@@ -16,136 +23,900 @@ func relativeCost(a, b int64) float64 {
 	return rc
 }
 
-// - find a machine type that can accomodate 2 indexed-search pods
-// - multiple by number of indexed-search pods to get cluster node pool
-// - place an indexed-search pod in each node
-// - use simple binpacking to place the rest and see how much is leftover per node
-// - simple binpacking:
-//        - sort by largest to smallest CPU (ties largest to smallest mem)
-//        - place them in this order in nodes with most space left
-//        - add additional nodes for left-overs if needed
-func packCommand(cctx *CommandContext, args []string) {
-	ms := cctx.machines[cctx.zone]
+// anchorSelector decides which pods anchor the cluster layout. Anchor pods are spread over
+// the nodes first and the machine type is chosen to fit them.
+//
+// The selector is given as
+//
+//	<name>              pods of the workload (Deployment or StatefulSet) with that name
+//	name:<name>         same as above
+//	regex:<regexp>      pods whose name matches the regular expression
+//	label:<key>=<value> pods whose template carries the label
+//	none                no anchors
+type anchorSelector struct {
+	workload   string
+	rgx        *regexp.Regexp
+	labelKey   string
+	labelValue string
+}
+
+func parseAnchorSelector(s string) (*anchorSelector, error) {
+	if s == "" || s == "none" {
+		return nil, nil
+	}
+
+	switch {
+	case strings.HasPrefix(s, "name:"):
+		return &anchorSelector{workload: strings.TrimPrefix(s, "name:")}, nil
+	case strings.HasPrefix(s, "regex:"):
+		rgx, err := regexp.Compile(strings.TrimPrefix(s, "regex:"))
+		if err != nil {
+			return nil, err
+		}
+		return &anchorSelector{rgx: rgx}, nil
+	case strings.HasPrefix(s, "label:"):
+		kv := strings.SplitN(strings.TrimPrefix(s, "label:"), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("expected label:<key>=<value>, got %s", s)
+		}
+		return &anchorSelector{labelKey: kv[0], labelValue: kv[1]}, nil
+	}
+	return &anchorSelector{workload: s}, nil
+}
+
+func (as *anchorSelector) matches(pod types.Resource) bool {
+	switch {
+	case as == nil:
+		return false
+	case as.rgx != nil:
+		return as.rgx.MatchString(pod.Name)
+	case as.labelKey != "":
+		v, ok := pod.Labels[as.labelKey]
+		return ok && v == as.labelValue
+	}
+	return pod.Workload == as.workload
+}
+
+// selectAnchors returns the names of the anchor pods in a stable order and the largest anchor
+func selectAnchors(pods map[string]types.Resource, as *anchorSelector) ([]string, types.Resource) {
+	anchorPods := make(map[string]types.Resource)
+	for k, v := range pods {
+		if as.matches(v) {
+			anchorPods[k] = v
+		}
+	}
+	if len(anchorPods) == 0 {
+		return nil, types.Resource{}
+	}
+
+	anchors := make([]string, 0, len(anchorPods))
+	for k := range anchorPods {
+		anchors = append(anchors, k)
+	}
+	sortPodNames(anchors)
+	return anchors, largestPod(anchorPods)
+}
+
+// sortPodNames sorts pod names by name, ordering replicas of the same workload by replica index
+func sortPodNames(names []string) {
+	replica := func(name string) (string, int) {
+		idx := strings.LastIndex(name, "-")
+		if idx < 0 {
+			return name, -1
+		}
+		n, err := strconv.Atoi(name[idx+1:])
+		if err != nil {
+			return name, -1
+		}
+		return name[:idx], n
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		bi, ni := replica(names[i])
+		bj, nj := replica(names[j])
+		if bi != bj {
+			return bi < bj
+		}
+		if ni != nj {
+			return ni < nj
+		}
+		return names[i] < names[j]
+	})
+}
+
+// largestPod returns the pod with the most CPU (ties broken by memory, then by name)
+func largestPod(pods map[string]types.Resource) types.Resource {
+	sorted := types.SortResources(pods, descendingSorter)
+	if len(sorted) == 0 {
+		return types.Resource{}
+	}
+	return pods[sorted[0]]
+}
 
-	// - find a machine type that can accomodate 2 indexed-search pods
-	//   we do a linear search for a machine type that minimizes the cost function 'relativeCost'
-	idxSearchR := cctx.pods["indexed-search-0"]
-	mem := idxSearchR.Memory * 2
-	cpu := idxSearchR.CPU * 2
+// descendingSorter orders resources from largest to smallest CPU, ties largest to smallest mem
+func descendingSorter(a types.Resource, b types.Resource) bool {
+	if a.CPU > b.CPU {
+		return true
+	}
+	if a.CPU == b.CPU && a.Memory > b.Memory {
+		return true
+	}
+	return false
+}
 
+// packPlan is the result of packing all pods onto nodes. Nodes added by the packer are of the
+// plan's machine type, nodes the plan started from (see packOnto) can be of any machine type.
+type packPlan struct {
+	machine       types.Resource
+	nodes         []*clusterNode
+	unschedulable []string
+	// problems with pinned pods
+	warnings []string
+	cost     *planCost
+}
+
+func (p *packPlan) capacity() types.Resource {
+	var res types.Resource
+	for _, node := range p.nodes {
+		res.CPU += node.machine.CPU
+		res.Memory += node.machine.Memory
+	}
+	return res
+}
+
+// homogeneous reports whether all nodes are of the plan's machine type
+func (p *packPlan) homogeneous() bool {
+	for _, node := range p.nodes {
+		if node.machine.Name != p.machine.Name {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *packPlan) free() types.Resource {
+	var res types.Resource
+	for _, node := range p.nodes {
+		res = types.AddResources(res, node.free)
+	}
+	return res
+}
+
+// waste is the average of the unused CPU and memory fractions of the cluster
+func (p *packPlan) waste() float64 {
+	capacity := p.capacity()
+	if capacity.CPU == 0 || capacity.Memory == 0 {
+		return 0
+	}
+	free := p.free()
+	return (float64(free.CPU)/float64(capacity.CPU) + float64(free.Memory)/float64(capacity.Memory)) / 2
+}
+
+// planComparators are the orders plans can be ranked by in nodes_pack -search
+var planComparators = map[string]func(a, b *packPlan) bool{
+	"nodes": func(a, b *packPlan) bool {
+		return len(a.nodes) < len(b.nodes)
+	},
+	"cpu": func(a, b *packPlan) bool {
+		return a.capacity().CPU < b.capacity().CPU
+	},
+	"mem": func(a, b *packPlan) bool {
+		return a.capacity().Memory < b.capacity().Memory
+	},
+	"waste": func(a, b *packPlan) bool {
+		return a.waste() < b.waste()
+	},
+	"price": func(a, b *packPlan) bool {
+		if a.cost == nil || b.cost == nil {
+			return a.cost != nil
+		}
+		return a.cost.monthly() < b.cost.monthly()
+	},
+}
+
+// rankOrders returns the names of the plan comparators, sorted
+func rankOrders() []string {
+	var names []string
+	for name := range planComparators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rankPlans sorts plans by the given order. Plans that leave pods unscheduled always come last.
+func rankPlans(plans []*packPlan, by string) error {
+	less, ok := planComparators[by]
+	if !ok {
+		return fmt.Errorf("unknown rank order %s", by)
+	}
+
+	sort.SliceStable(plans, func(i, j int) bool {
+		a, b := plans[i], plans[j]
+		if (len(a.unschedulable) == 0) != (len(b.unschedulable) == 0) {
+			return len(a.unschedulable) == 0
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.machine.Name < b.machine.Name
+	})
+	return nil
+}
+
+// chooseMachine does a linear search for a machine type that can accomodate the given resources
+// and minimizes the cost function 'relativeCost'. Machine types are visited by name, so on ties
+// the first name wins.
+func chooseMachine(ms map[string]types.Resource, want types.Resource) string {
 	bestMachineType := ""
 	minCost := 1.0
 
-	for mName, mResource := range ms {
-		cost := math.Max(relativeCost(mResource.Memory, mem), relativeCost(mResource.CPU, cpu))
+	for _, mName := range sortedMachineNames(ms) {
+		mResource := ms[mName]
+		cost := math.Max(relativeCost(mResource.Memory, want.Memory), relativeCost(mResource.CPU, want.CPU))
 		if cost < minCost {
 			minCost = cost
 			bestMachineType = mName
 		}
 	}
+	return bestMachineType
+}
 
-	// - multiple by number of indexed-search pods to get cluster node pool
-	indexedSearchReplicas := 1
-	_, ok := cctx.pods[fmt.Sprintf("indexed-search-%d", indexedSearchReplicas)]
-	for ok {
-		indexedSearchReplicas++
-		_, ok = cctx.pods[fmt.Sprintf("indexed-search-%d", indexedSearchReplicas)]
+func sortedMachineNames(ms map[string]types.Resource) []string {
+	names := make([]string, 0, len(ms))
+	for name := range ms {
+		names = append(names, name)
 	}
-	fmt.Printf("replica count for indexed search is %d\n", indexedSearchReplicas)
+	sort.Strings(names)
+	return names
+}
+
+// packStrategies are the binpacking strategies nodes_pack supports:
+//
+//	wfd     worst-fit decreasing, place each pod on the node with most space left
+//	bfd     best-fit decreasing, place each pod on the node it leaves least space on
+//	ffd     first-fit decreasing, place each pod on the first node it fits on
+//	random  place pods in random order on a random node they fit on, seeded by packStrategy.seed
+var packStrategies = []string{"wfd", "bfd", "ffd", "random"}
+
+// packStrategy selects the binpacking strategy. Given the same inputs, strategy and seed,
+// packPods always returns the same plan.
+type packStrategy struct {
+	name     string
+	seed     int64
+	headroom headroom
+}
 
-	// - place an indexed-search pod in each node
-	numNodes := indexedSearchReplicas
-	nodeAssign := make(map[string][]string)
-	freeSpace := make(map[string]types.Resource)
-	for i := 0; i < numNodes; i++ {
-		name := fmt.Sprintf("node-%d", i)
-		freeSpace[name] = types.Resource{
-			Name: name,
-			Memory: ms[bestMachineType].Memory - idxSearchR.Memory,
-			CPU: ms[bestMachineType].CPU - idxSearchR.CPU,
+func (ps packStrategy) validate() error {
+	if err := ps.headroom.validate(); err != nil {
+		return err
+	}
+	for _, name := range packStrategies {
+		if ps.name == name {
+			return nil
 		}
-		nodeAssign[name] = append(nodeAssign[name], fmt.Sprintf("indexed-search-%d", i))
 	}
+	return fmt.Errorf("unknown strategy %s, expected one of %s", ps.name, strings.Join(packStrategies, ", "))
+}
 
-	descendingSorter := func(a types.Resource, b types.Resource) bool {
-		if a.CPU > b.CPU {
-			return true
+// headroom is the percentage of the CPU and memory of each node that packing keeps free
+type headroom struct {
+	cpu    int
+	memory int
+}
+
+func (h headroom) validate() error {
+	if h.cpu < 0 || h.cpu >= 100 || h.memory < 0 || h.memory >= 100 {
+		return fmt.Errorf("headroom has to be at least 0 and less than 100 percent")
+	}
+	return nil
+}
+
+// usable returns the resources of a machine left for pods
+func (h headroom) usable(m types.Resource) types.Resource {
+	return types.Resource{
+		CPU:    m.CPU * int64(100-h.cpu) / 100,
+		Memory: m.Memory * int64(100-h.memory) / 100,
+	}
+}
+
+// needed returns the resources a machine needs to leave r for pods
+func (h headroom) needed(r types.Resource) types.Resource {
+	return types.Resource{
+		CPU:    (r.CPU*100 + int64(99-h.cpu)) / int64(100-h.cpu),
+		Memory: (r.Memory*100 + int64(99-h.memory)) / int64(100-h.memory),
+	}
+}
+
+// reserve keeps the headroom of an empty node free
+func (h headroom) reserve(node *clusterNode) {
+	node.free = h.usable(node.machine)
+}
+
+// moreFree reports whether a has more space left than b, comparing CPU first and memory second
+func moreFree(a, b types.Resource) bool {
+	return a.CPU > b.CPU || (a.CPU == b.CPU && a.Memory > b.Memory)
+}
+
+// chooser returns the function picking the node a pod goes to, or nil if it can't be placed
+// on any of the existing nodes. Ties always go to the node that was created first.
+func (ps packStrategy) chooser(rng *rand.Rand) func(nodes []*clusterNode, pod types.Resource) *clusterNode {
+	switch ps.name {
+	case "bfd":
+		return func(nodes []*clusterNode, pod types.Resource) *clusterNode {
+			var best *clusterNode
+			for _, node := range nodes {
+				if node.fits(pod) && (best == nil || moreFree(best.free, node.free)) {
+					best = node
+				}
+			}
+			return best
 		}
-		if a.CPU == b.CPU && a.Memory > b.Memory {
-			return true
+	case "ffd":
+		return func(nodes []*clusterNode, pod types.Resource) *clusterNode {
+			for _, node := range nodes {
+				if node.fits(pod) {
+					return node
+				}
+			}
+			return nil
+		}
+	case "random":
+		return func(nodes []*clusterNode, pod types.Resource) *clusterNode {
+			var candidates []*clusterNode
+			for _, node := range nodes {
+				if node.fits(pod) {
+					candidates = append(candidates, node)
+				}
+			}
+			if len(candidates) == 0 {
+				return nil
+			}
+			return candidates[rng.Intn(len(candidates))]
+		}
+	}
+
+	// wfd only tries the node with most space left
+	return func(nodes []*clusterNode, pod types.Resource) *clusterNode {
+		var mostFree *clusterNode
+		for _, node := range nodes {
+			if mostFree == nil || moreFree(node.free, mostFree.free) {
+				mostFree = node
+			}
+		}
+		if mostFree == nil || !mostFree.fits(pod) {
+			return nil
+		}
+		return mostFree
+	}
+}
+
+// packPods places anchorsPerNode anchor pods in each node and binpacks the remaining pods onto
+// nodes of the given machine type, adding nodes as needed.
+func packPods(pods map[string]types.Resource, anchors []string, anchorsPerNode int, machine types.Resource,
+	ps packStrategy) *packPlan {
+	return packOnto(nil, true, pods, anchors, anchorsPerNode, nil, machine, ps)
+}
+
+// packOnto is packPods starting from the given empty nodes. Nodes are added of the given machine type
+// if grow is set, otherwise pods that don't fit on the given nodes are reported as unschedulable.
+//
+// Pinned pods are placed on their node before anything else, even if that overcommits the node.
+// Nodes named node-<n> that pins refer to are added if grow is set.
+//
+// The pods are visited from largest to smallest (ties by name), or in seeded random order for the
+// random strategy, and placed according to the strategy. Pods that don't fit on any node are
+// retried after adding another node.
+func packOnto(nodes []*clusterNode, grow bool, pods map[string]types.Resource, anchors []string,
+	anchorsPerNode int, pins map[string]string, machine types.Resource, ps packStrategy) *packPlan {
+	plan := &packPlan{machine: machine, nodes: nodes}
+	for _, node := range nodes {
+		ps.headroom.reserve(node)
+	}
+
+	addNode := func() *clusterNode {
+		node := newClusterNode(nextNodeName(plan.nodes, "node"), machine, nil)
+		ps.headroom.reserve(node)
+		plan.nodes = append(plan.nodes, node)
+		return node
+	}
+
+	pinned := make(map[string]bool)
+	pinnedPods := make([]string, 0, len(pins))
+	for pod := range pins {
+		if _, ok := pods[pod]; ok {
+			pinnedPods = append(pinnedPods, pod)
+		}
+	}
+	sortPodNames(pinnedPods)
+	for _, name := range pinnedPods {
+		nodeName := pins[name]
+		_, node := findNode(plan.nodes, nodeName)
+		if n, ok := nodeIndex(nodeName, "node"); ok && grow {
+			for node == nil && nextNodeIndex(plan.nodes, "node") <= n {
+				addNode()
+				_, node = findNode(plan.nodes, nodeName)
+			}
+		}
+		if node == nil {
+			plan.warnings = append(plan.warnings, fmt.Sprintf("%s is pinned to unknown node %s", name, nodeName))
+			continue
+		}
+		node.assign(pods[name])
+		pinned[name] = true
+		if over, ok := node.overcommitted(); ok {
+			plan.warnings = append(plan.warnings, fmt.Sprintf("pinning %s overcommits %s by %s",
+				name, nodeName, over.String()))
+		}
+	}
+
+	var unpinnedAnchors []string
+	for _, a := range anchors {
+		if !pinned[a] {
+			unpinnedAnchors = append(unpinnedAnchors, a)
+		}
+	}
+
+	isAnchor := make(map[string]bool)
+	for i, a := range unpinnedAnchors {
+		idx := i / anchorsPerNode
+		for grow && idx >= len(plan.nodes) {
+			addNode()
+		}
+		// anchors that don't fit their node are packed like any other pod
+		if idx < len(plan.nodes) && plan.nodes[idx].fits(pods[a]) {
+			plan.nodes[idx].assign(pods[a])
+			isAnchor[a] = true
 		}
-		return false
 	}
 
 	// - use simple binpacking to place the rest and see how much is leftover per node
 	todo := make(map[string]types.Resource)
-	for k, v := range cctx.pods {
-		if !strings.HasPrefix(k, "indexed-search") {
+	for k, v := range pods {
+		if !isAnchor[k] && !pinned[k] {
 			todo[k] = v
 		}
 	}
 	sortedTodo := types.SortResources(todo, descendingSorter)
 
-	numNotAssigned := len(sortedTodo)
-	for numNotAssigned > 0 {
-		from := 0
-		for from < len(sortedTodo) {
-			i := from
-			for i < len(sortedTodo) && sortedTodo[i] == "" {
-				i++
-			}
-			if i == len(sortedTodo) {
-				break
-			}
-			pod := todo[sortedTodo[i]]
-
-			mostFreeNodeName := ""
-			mostFreeMem := int64(0)
-			mostFreeCPU := int64(0)
-
-			for nodeName, node := range freeSpace {
-				if node.CPU > mostFreeCPU {
-					mostFreeNodeName = nodeName
-					mostFreeCPU = node.CPU
-					mostFreeMem = node.Memory
-				} else if node.CPU == mostFreeCPU && node.Memory > mostFreeMem {
-					mostFreeNodeName = nodeName
-					mostFreeCPU = node.CPU
-					mostFreeMem = node.Memory
-				}
+	var rng *rand.Rand
+	if ps.name == "random" {
+		rng = rand.New(rand.NewSource(ps.seed))
+		rng.Shuffle(len(sortedTodo), func(i, j int) {
+			sortedTodo[i], sortedTodo[j] = sortedTodo[j], sortedTodo[i]
+		})
+	}
+	choose := ps.chooser(rng)
+
+	for len(sortedTodo) > 0 {
+		var notAssigned []string
+		for _, name := range sortedTodo {
+			pod := todo[name]
+			node := choose(plan.nodes, pod)
+			if node == nil {
+				notAssigned = append(notAssigned, name)
+				continue
 			}
-			if mostFreeNodeName == "" {
-				break
+			node.assign(pod)
+		}
+
+		// only pods that fit on an empty node of the machine type are worth another node
+		usable := ps.headroom.usable(machine)
+		sortedTodo = nil
+		for _, name := range notAssigned {
+			pod := todo[name]
+			if grow && pod.CPU <= usable.CPU && pod.Memory <= usable.Memory {
+				sortedTodo = append(sortedTodo, name)
+			} else {
+				plan.unschedulable = append(plan.unschedulable, name)
 			}
-			nodeName, node := mostFreeNodeName, freeSpace[mostFreeNodeName]
-			if node.Memory-pod.Memory >= 0 && node.CPU-pod.CPU >= 0 {
-				freeSpace[nodeName] = types.Resource{
-					Memory: node.Memory - pod.Memory,
-					CPU:    node.CPU - pod.CPU,
-				}
-				nodeAssign[nodeName] = append(nodeAssign[nodeName], pod.Name)
-				numNotAssigned--
-				sortedTodo[i] = ""
+		}
+		if len(sortedTodo) > 0 {
+			addNode()
+		}
+	}
+
+	sortPodNames(plan.unschedulable)
+	return plan
+}
+
+// searchPlans packs the pods onto every machine type that can hold anchorsPerNode anchor pods.
+// Each machine type is packed in its own goroutine.
+func searchPlans(pods map[string]types.Resource, anchors []string, anchorsPerNode int, pins map[string]string,
+	anchorR types.Resource, ms map[string]types.Resource, ps packStrategy) []*packPlan {
+	var candidates []types.Resource
+	for _, name := range sortedMachineNames(ms) {
+		m := ms[name]
+		u := ps.headroom.usable(m)
+		if u.CPU >= anchorR.CPU*int64(anchorsPerNode) && u.Memory >= anchorR.Memory*int64(anchorsPerNode) {
+			candidates = append(candidates, m)
+		}
+	}
+
+	plans := make([]*packPlan, len(candidates))
+
+	var wg sync.WaitGroup
+	for i, m := range candidates {
+		wg.Add(1)
+		go func(i int, m types.Resource) {
+			defer wg.Done()
+			plans[i] = packOnto(nil, true, pods, anchors, anchorsPerNode, pins, m, ps)
+		}(i, m)
+	}
+	wg.Wait()
+
+	return plans
+}
+
+func printPlan(cctx *CommandContext, plan *packPlan) {
+	homogeneous := plan.homogeneous()
+	if homogeneous {
+		cctx.printf("cluster with %d nodes of machine type %s\n", len(plan.nodes), plan.machine.FormatMachine(cctx.config.Units))
+	} else {
+		cctx.printf("cluster with %d nodes\n", len(plan.nodes))
+	}
+	cctx.println("Pod assignment as follows:")
+	for _, node := range plan.nodes {
+		if homogeneous {
+			cctx.printf("%s: [%s], free %s\n", node.name, strings.Join(node.pods, ", "), node.free.FormatMachine(cctx.config.Units))
+		} else {
+			cctx.printf("%s (%s): [%s], free %s\n", node.name, node.machine.Name,
+				strings.Join(node.pods, ", "), node.free.FormatMachine(cctx.config.Units))
+		}
+	}
+	if len(plan.unschedulable) > 0 {
+		cctx.printf("pods that could not be placed: [%s]\n", strings.Join(plan.unschedulable, ", "))
+	}
+	for _, w := range plan.warnings {
+		cctx.println("warning:", w)
+	}
+	printCost(cctx, plan.cost, len(plan.nodes))
+}
+
+// printPlanComparison prints the plans side by side, one column per plan
+func printPlanComparison(cctx *CommandContext, plans []*packPlan) {
+	w := tabwriter.NewWriter(cctx.out, 0, 0, 3, ' ', tabwriter.AlignRight)
+	unit := cctx.config.Units
+
+	row := func(label string, value func(p *packPlan) string) {
+		_, _ = fmt.Fprintf(w, "%s\t", label)
+		for _, p := range plans {
+			_, _ = fmt.Fprintf(w, "%s\t", value(p))
+		}
+		_, _ = fmt.Fprintln(w)
+	}
+
+	row("", func(p *packPlan) string { return p.machine.Name })
+	row("nodes", func(p *packPlan) string { return strconv.Itoa(len(p.nodes)) })
+	row("vCPU", func(p *packPlan) string { return types.FormatCPU(p.capacity().CPU) })
+	row("mem "+unit, func(p *packPlan) string { return types.FormatMachineMemory(p.capacity().Memory, unit) })
+	row("free vCPU", func(p *packPlan) string { return types.FormatCPU(p.free().CPU) })
+	row("free mem "+unit, func(p *packPlan) string { return types.FormatMachineMemory(p.free().Memory, unit) })
+	row("waste", func(p *packPlan) string { return fmt.Sprintf("%.1f%%", p.waste()*100) })
+	row("unschedulable", func(p *packPlan) string { return strconv.Itoa(len(p.unschedulable)) })
+	row("$/month", func(p *packPlan) string {
+		if p.cost == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f", p.cost.monthly())
+	})
+	_ = w.Flush()
+}
+
+// packOptions are the flags of nodes_pack
+type packOptions struct {
+	anchor         string
+	anchorsPerNode int
+	anchorFit      int
+	search         bool
+	rank           string
+	top            int
+	pricing        string
+	machine        string
+	spotKind       string
+	spotAnnotation string
+	spotFilter     string
+	spotMachine    string
+	existing       bool
+	noGrow         bool
+	strategy       string
+	seed           int64
+	headroomCPU    int
+	headroomMemory int
+	allocatable    bool
+	custom         bool
+	output         string
+}
+
+func (o *packOptions) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("nodes_pack", flag.ContinueOnError)
+	fs.StringVar(&o.anchor, "anchor", "indexed-search", "anchor workload: <name>, name:<name>, regex:<regexp>, label:<key>=<value> or none")
+	fs.IntVar(&o.anchorsPerNode, "anchors-per-node", 1, "number of anchor pods placed on each node")
+	fs.IntVar(&o.anchorFit, "anchor-fit", 2, "number of anchor pods the machine type has to be able to hold")
+	fs.BoolVar(&o.search, "search", false, "pack onto every machine type in the zone and rank the plans")
+	fs.StringVar(&o.rank, "rank", "nodes", "rank order of -search: nodes, cpu, mem, waste or price")
+	fs.IntVar(&o.top, "top", 5, "number of plans -search shows")
+	fs.StringVar(&o.pricing, "pricing", types.PricingOnDemand, "pricing model for cost estimates: ondemand, spot, 1y or 3y")
+	fs.StringVar(&o.machine, "machine", "", "machine type to use instead of choosing one")
+	fs.StringVar(&o.spotKind, "spot-kind", "", "comma separated workload kinds placed on the spot pool, e.g. Deployment")
+	fs.StringVar(&o.spotAnnotation, "spot-annotation", "", "pods with annotation <key>=<value> are placed on the spot pool")
+	fs.StringVar(&o.spotFilter, "spot-filter", "", "pods passing the filter expression are placed on the spot pool")
+	fs.StringVar(&o.spotMachine, "spot-machine", "", "machine type of the spot pool instead of choosing the cheapest")
+	fs.BoolVar(&o.existing, "existing", false, "pack onto the nodes of the cluster (see nodes_add) instead of a new cluster")
+	fs.BoolVar(&o.noGrow, "no-grow", false, "with -existing, don't add nodes for pods that don't fit")
+	fs.StringVar(&o.strategy, "strategy", "wfd", "binpacking strategy: "+strings.Join(packStrategies, ", "))
+	fs.Int64Var(&o.seed, "seed", 1, "seed of the random strategy")
+	fs.IntVar(&o.headroomCPU, "headroom-cpu", 0, "percentage of the CPU of each node kept free")
+	fs.IntVar(&o.headroomMemory, "headroom-mem", 0, "percentage of the memory of each node kept free")
+	fs.BoolVar(&o.allocatable, "allocatable", false, "pack onto the resources left after the provider's system reservations")
+	fs.BoolVar(&o.custom, "custom", false, "consider GCE custom machine types shaped for the pods")
+	outputFlag(fs, &o.output)
+	return fs
+}
+
+// - find a machine type that can accomodate anchor-fit anchor pods (by default 2 indexed-search pods)
+//   or, with -search, pack onto every machine type and rank the resulting plans
+// - place anchors-per-node anchor pods (by default 1) in each node, which gives the initial node pool
+// - use simple binpacking to place the rest and see how much is leftover per node
+// - simple binpacking:
+//   - sort by largest to smallest CPU (ties largest to smallest mem)
+//   - place them in this order in nodes with most space left
+//   - add additional nodes for left-overs if needed
+//
+// if no pod matches the anchor selector the machine type is sized by the largest pod and the
+// node pool is built up by the binpacking alone.
+func packCommand(cctx *CommandContext, args []string) error {
+	var o packOptions
+	fs := o.flagSet()
+	o.strategy = cctx.config.Strategy
+	o.headroomCPU = cctx.config.Headroom.CPU
+	o.headroomMemory = cctx.config.Headroom.Memory
+
+	err := parseFlags(cctx, fs, args)
+	if err != nil {
+		return err
+	}
+	format, err := cctx.outputFormat(o.output)
+	if err != nil {
+		return err
+	}
+	table := format == outputTable
+	if o.anchorsPerNode < 1 || o.anchorFit < 1 {
+		return argsErrorf("anchors-per-node and anchor-fit have to be at least 1")
+	}
+	if o.anchorsPerNode > o.anchorFit {
+		return argsErrorf("anchors-per-node can't be greater than anchor-fit")
+	}
+	if _, ok := planComparators[o.rank]; !ok {
+		return argsErrorf("unknown rank order %s", o.rank)
+	}
+	if _, err := (types.Price{}).Hourly(o.pricing); err != nil {
+		return argsErrorf("%v", err)
+	}
+	ps := packStrategy{
+		name:     o.strategy,
+		seed:     o.seed,
+		headroom: headroom{cpu: o.headroomCPU, memory: o.headroomMemory},
+	}
+	if err := ps.validate(); err != nil {
+		return argsErrorf("%v", err)
+	}
+
+	as, err := parseAnchorSelector(o.anchor)
+	if err != nil {
+		return argsErrorf("invalid anchor: %v", err)
+	}
+
+	sc, err := newSpotClassifier(o.spotKind, o.spotAnnotation, o.spotFilter)
+	if err != nil {
+		return argsErrorf("invalid spot pool classifier: %v", err)
+	}
+
+	ms := cctx.machines[cctx.zone]
+	if len(ms) == 0 {
+		return missingErrorf("no machines known for zone %s. please execute command machines_fetch", cctx.zone)
+	}
+	if len(cctx.pods) == 0 {
+		return missingErrorf("no pods to pack. please execute command manifests_read")
+	}
+
+	zp := cctx.prices[cctx.zone]
+	if o.search && o.rank == "price" && len(zp.Machines) == 0 && !o.custom {
+		return missingErrorf("no prices known for zone %s, load prices with prices_load", cctx.zone)
+	}
+	var customs []types.Resource
+	cp, hasCustom := cctx.provider.(customMachineProvider)
+	if o.custom {
+		if !hasCustom {
+			return argsErrorf("-custom isn't supported, the %s provider has no custom machine types", cctx.provider.Name())
+		}
+		customs = cp.CustomShapes(cctx.pods, ps.headroom, ms)
+	}
+	if hasCustom {
+		for _, name := range []string{o.machine, o.spotMachine} {
+			if m, ok := cp.CustomMachine(name, ms); ok {
+				customs = append(customs, m)
 			}
-			from++
 		}
+	}
+	if len(customs) > 0 {
+		ms, zp = withCustomMachines(ms, zp, customs)
+	}
+	if o.allocatable {
+		ms = allocatableMachines(cctx.provider, ms)
+	}
+
+	onDemand := &tier{
+		anchor:      as,
+		anchorDesc:  o.anchor,
+		perNode:     o.anchorsPerNode,
+		fit:         o.anchorFit,
+		machineType: o.machine,
+		pricing:     o.pricing,
+		strategy:    ps,
+	}
+	if table {
+		onDemand.info = cctx.info
+	}
+	if o.search {
+		onDemand.rank = o.rank
+	}
+	if o.existing {
+		if o.search || sc != nil {
+			return argsErrorf("-existing can't be combined with -search or a spot pool")
+		}
+		if len(cctx.nodes) == 0 {
+			return missingErrorf("the cluster has no nodes. please execute command nodes_add")
+		}
+		onDemand.existing = emptyNodes(cctx.nodes)
+		onDemand.grow = !o.noGrow
+	}
 
-		if numNotAssigned > 0 {
-			nodeName := fmt.Sprintf("node-%d", numNodes)
-			numNodes++
-			freeSpace[nodeName] = types.Resource{
-				Name:   nodeName,
-				Memory: ms[bestMachineType].Memory,
-				CPU:    ms[bestMachineType].CPU,
+	if sc == nil {
+		onDemand.pins = cctx.pins
+		plans, err := onDemand.plan(cctx.pods, ms, zp)
+		if err != nil {
+			return err
+		}
+		if table {
+			printPlans(cctx, plans, o.top)
+		} else {
+			err := writePack(cctx.out, format, packRecord{Pools: []poolRecord{
+				newPoolRecord("default", len(cctx.pods), plans, o.top),
+			}})
+			if err != nil {
+				return err
 			}
 		}
+		cctx.nodes = plans[0].nodes
+		return nil
+	}
+
+	// two-tier plan: stateless pods go to the spot pool, everything else stays on on-demand nodes
+	if len(cctx.pins) > 0 {
+		cctx.infoln("warning: pinned pods are packed like any other pod when using a spot pool")
+	}
+	onDemandPods, spotPods := sc.split(cctx.pods)
+
+	spot := &tier{
+		perNode:     1,
+		fit:         o.anchorFit,
+		machineType: o.spotMachine,
+		pricing:     types.PricingSpot,
+		rank:        "nodes",
+		nodePrefix:  "spot",
+		strategy:    ps,
+	}
+	if table {
+		spot.info = cctx.info
+	}
+	if len(zp.Machines) > 0 {
+		spot.rank = "price"
+	}
+
+	var onDemandPlans, spotPlans []*packPlan
+	if len(onDemandPods) > 0 {
+		onDemandPlans, err = onDemand.plan(onDemandPods, ms, zp)
+		if err != nil {
+			return err
+		}
+		if table {
+			cctx.printf("on-demand pool (%d pods):\n", len(onDemandPods))
+			printPlans(cctx, onDemandPlans, o.top)
+			cctx.println()
+		}
+	}
+	if len(spotPods) > 0 {
+		spotPlans, err = spot.plan(spotPods, ms, zp)
+		if err != nil {
+			return err
+		}
+		if table {
+			cctx.printf("spot pool (%d pods):\n", len(spotPods))
+			printPlans(cctx, spotPlans[:1], 1)
+			cctx.println()
+		}
+	}
+
+	// compare against putting everything on on-demand nodes
+	allOnDemand := *onDemand
+	allOnDemand.pricing = types.PricingOnDemand
+	allOnDemand.info = nil
+	basePlans, err := allOnDemand.plan(cctx.pods, ms, zp)
+	if err != nil {
+		return fmt.Errorf("failed to compute all on-demand plan: %w", err)
+	}
+
+	var tiered []*packPlan
+	var nodes []*clusterNode
+	if len(onDemandPlans) > 0 {
+		tiered = append(tiered, onDemandPlans[0])
+		nodes = append(nodes, onDemandPlans[0].nodes...)
+	}
+	if len(spotPlans) > 0 {
+		tiered = append(tiered, spotPlans[0])
+		nodes = append(nodes, spotPlans[0].nodes...)
+	}
+	if table {
+		printSavings(cctx, tiered, basePlans[0])
+	} else {
+		pr := packRecord{}
+		if len(onDemandPlans) > 0 {
+			pr.Pools = append(pr.Pools, newPoolRecord("on-demand", len(onDemandPods), onDemandPlans, o.top))
+		}
+		if len(spotPlans) > 0 {
+			pr.Pools = append(pr.Pools, newPoolRecord("spot", len(spotPods), spotPlans, 1))
+		}
+		baseline := newPlanRecord(basePlans[0])
+		pr.Baseline = &baseline
+		err := writePack(cctx.out, format, pr)
+		if err != nil {
+			return err
+		}
+	}
+	cctx.nodes = nodes
+	return nil
+}
+
+func printPlans(cctx *CommandContext, plans []*packPlan, top int) {
+	if len(plans) > 1 {
+		if top > 0 && len(plans) > top {
+			plans = plans[:top]
+		}
+		printPlanComparison(cctx, plans)
+		cctx.println()
+	}
+	printPlan(cctx, plans[0])
+}
+
+// printSavings compares the cost of a tiered plan with the cost of a plan using on-demand nodes only
+func printSavings(cctx *CommandContext, tiered []*packPlan, base *packPlan) {
+	var monthly float64
+	numNodes := 0
+	for _, plan := range tiered {
+		if plan.cost == nil {
+			cctx.println("missing prices, cannot compare with all on-demand plan")
+			return
+		}
+		monthly += plan.cost.monthly()
+		numNodes += len(plan.nodes)
+	}
+	if base.cost == nil {
+		cctx.println("missing prices, cannot compare with all on-demand plan")
+		return
 	}
 
-	fmt.Printf("cluster with %d nodes of machine type %s\n", numNodes, ms[bestMachineType].String())
-	fmt.Println("Pod assignment as follows:")
-	for i := 0; i < numNodes; i++ {
-		nodeName := fmt.Sprintf("node-%d", i)
-		fmt.Printf("%s: [%s], free %s\n", nodeName,
-			strings.Join(nodeAssign[nodeName], ", "), freeSpace[nodeName].String())
+	baseMonthly := base.cost.monthly()
+	cctx.printf("tiered plan: %d nodes, $%.2f/month, $%.2f/year\n", numNodes, monthly, monthly*12)
+	cctx.printf("all on-demand plan: %d nodes of %s, $%.2f/month, $%.2f/year\n", len(base.nodes),
+		base.machine.Name, baseMonthly, baseMonthly*12)
+	savings := baseMonthly - monthly
+	pct := 0.0
+	if baseMonthly > 0 {
+		pct = savings / baseMonthly * 100
 	}
+	cctx.printf("savings: $%.2f/month, $%.2f/year (%.1f%%)\n", savings, savings*12, pct)
 }
@@ -0,0 +1,186 @@
+package nodepacker
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"nodepacker/types"
+)
+
+func TestSelectAnchors(t *testing.T) {
+	pods := map[string]types.Resource{
+		"indexed-search-0":  {Name: "indexed-search-0", Workload: "indexed-search", CPU: 4000, Memory: 8000},
+		"indexed-search-1":  {Name: "indexed-search-1", Workload: "indexed-search", CPU: 4000, Memory: 8000},
+		"indexed-search-10": {Name: "indexed-search-10", Workload: "indexed-search", CPU: 4000, Memory: 8000},
+		"indexed-search-2":  {Name: "indexed-search-2", Workload: "indexed-search", CPU: 4000, Memory: 8000},
+		"gitserver-0":       {Name: "gitserver-0", Workload: "gitserver", CPU: 4000, Memory: 8000, Labels: map[string]string{"app": "gitserver"}},
+		"searcher":          {Name: "searcher", Workload: "searcher", CPU: 500, Memory: 500},
+	}
+
+	fixture := []struct {
+		selector string
+		want     []string
+	}{
+		{"indexed-search", []string{"indexed-search-0", "indexed-search-1", "indexed-search-2", "indexed-search-10"}},
+		{"name:searcher", []string{"searcher"}},
+		{"regex:^(gitserver|searcher)", []string{"gitserver-0", "searcher"}},
+		{"label:app=gitserver", []string{"gitserver-0"}},
+		{"none", nil},
+		{"pgsql", nil},
+	}
+
+	for _, f := range fixture {
+		as, err := parseAnchorSelector(f.selector)
+		if err != nil {
+			t.Fatal(f.selector, err)
+		}
+		got, _ := selectAnchors(pods, as)
+		if !reflect.DeepEqual(got, f.want) {
+			t.Errorf("%s: got %v, want %v", f.selector, got, f.want)
+		}
+	}
+
+	if _, err := parseAnchorSelector("label:app"); err == nil {
+		t.Error("expected error for label selector without value")
+	}
+}
+
+func TestRankPlans(t *testing.T) {
+	pods := map[string]types.Resource{
+		"a": {Name: "a", CPU: 3000, Memory: 3000},
+		"b": {Name: "b", CPU: 3000, Memory: 3000},
+		"c": {Name: "c", CPU: 1000, Memory: 1000},
+		"d": {Name: "d", CPU: 6000, Memory: 6000},
+	}
+	ms := map[string]types.Resource{
+		"small": {Name: "small", CPU: 4000, Memory: 4000},
+		"large": {Name: "large", CPU: 8000, Memory: 8000},
+		"huge":  {Name: "huge", CPU: 16000, Memory: 16000},
+	}
+
+	plans := searchPlans(pods, nil, 1, nil, types.Resource{}, ms, packStrategy{name: "wfd"})
+	if err := rankPlans(plans, "nodes"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, p := range plans {
+		got = append(got, p.machine.Name)
+	}
+	want := []string{"huge", "large", "small"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if len(plans[2].unschedulable) != 1 || plans[2].unschedulable[0] != "d" {
+		t.Errorf("expected d to be unschedulable on small, got %v", plans[2].unschedulable)
+	}
+
+	if err := rankPlans(plans, "price-per-banana"); err == nil {
+		t.Error("expected error for unknown rank order")
+	}
+}
+
+func TestPackPodsDeterministic(t *testing.T) {
+	pods := make(map[string]types.Resource)
+	for i := 0; i < 40; i++ {
+		name := fmt.Sprintf("pod-%d", i)
+		// many pods of identical size, so ties have to be broken explicitly
+		pods[name] = types.Resource{Name: name, CPU: int64(500 * (1 + i%3)), Memory: int64(1000 * (1 + i%2))}
+	}
+	machine := types.Resource{Name: "m", CPU: 4000, Memory: 8000}
+
+	for _, strategy := range packStrategies {
+		ps := packStrategy{name: strategy, seed: 42}
+		want := packPods(pods, nil, 1, machine, ps)
+		for i := 0; i < 20; i++ {
+			got := packPods(pods, nil, 1, machine, ps)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: packing is not deterministic", strategy)
+			}
+		}
+
+		assigned := 0
+		for _, node := range want.nodes {
+			if node.free.CPU < 0 || node.free.Memory < 0 {
+				t.Errorf("%s: node %s is overcommitted", strategy, node.name)
+			}
+			assigned += len(node.pods)
+		}
+		if assigned != len(pods) {
+			t.Errorf("%s: assigned %d of %d pods", strategy, assigned, len(pods))
+		}
+	}
+
+	a := packPods(pods, nil, 1, machine, packStrategy{name: "random", seed: 1})
+	b := packPods(pods, nil, 1, machine, packStrategy{name: "random", seed: 2})
+	if reflect.DeepEqual(a, b) {
+		t.Error("expected different seeds to give different plans")
+	}
+}
+
+func TestPackPodsHeadroom(t *testing.T) {
+	pods := make(map[string]types.Resource)
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("pod-%d", i)
+		pods[name] = types.Resource{Name: name, CPU: 1000, Memory: 1000}
+	}
+	machine := types.Resource{Name: "m", CPU: 4000, Memory: 8000}
+
+	plan := packPods(pods, nil, 1, machine, packStrategy{name: "wfd", headroom: headroom{cpu: 25}})
+	if len(plan.nodes) != 3 {
+		t.Fatalf("expected 3 nodes with 3 vCPU usable each, got %d", len(plan.nodes))
+	}
+	for _, node := range plan.nodes {
+		if node.free.CPU < 0 {
+			t.Errorf("node %s uses the headroom: %s", node.name, node.free.String())
+		}
+	}
+
+	// a pod that fits the machine but not its usable part doesn't get a node
+	pods["big"] = types.Resource{Name: "big", CPU: 3500, Memory: 1000}
+	plan = packPods(pods, nil, 1, machine, packStrategy{name: "wfd", headroom: headroom{cpu: 25}})
+	if len(plan.nodes) != 3 || !reflect.DeepEqual(plan.unschedulable, []string{"big"}) {
+		t.Errorf("expected big to be unschedulable on 3 nodes, got %v on %d nodes", plan.unschedulable, len(plan.nodes))
+	}
+
+	h := headroom{cpu: 25, memory: 10}
+	if got := h.usable(h.needed(types.Resource{CPU: 3000, Memory: 900})); got.CPU < 3000 || got.Memory < 900 {
+		t.Errorf("expected a machine of the needed size to leave the resources usable, got %s", got.String())
+	}
+}
+
+func TestPackStrategyChooser(t *testing.T) {
+	nodes := []*clusterNode{
+		{name: "node-0", free: types.Resource{CPU: 1000, Memory: 1000}},
+		{name: "node-1", free: types.Resource{CPU: 3000, Memory: 3000}},
+		{name: "node-2", free: types.Resource{CPU: 2000, Memory: 2000}},
+	}
+	pod := types.Resource{Name: "pod", CPU: 1500, Memory: 1500}
+
+	tests := []struct {
+		strategy string
+		want     string
+	}{
+		{"wfd", "node-1"},
+		{"bfd", "node-2"},
+		{"ffd", "node-1"},
+	}
+	for _, test := range tests {
+		ps := packStrategy{name: test.strategy}
+		if err := ps.validate(); err != nil {
+			t.Fatal(err)
+		}
+		got := ps.chooser(nil)(nodes, pod)
+		if got == nil || got.name != test.want {
+			t.Errorf("%s: expected %s, got %v", test.strategy, test.want, got)
+		}
+		if got := ps.chooser(nil)(nodes, types.Resource{CPU: 4000}); got != nil {
+			t.Errorf("%s: expected no node for a pod that doesn't fit, got %s", test.strategy, got.name)
+		}
+	}
+
+	if err := (packStrategy{name: "nfd"}).validate(); err == nil {
+		t.Error("expected an unknown strategy to fail")
+	}
+}
@@ -1,48 +1,161 @@
 package nodepacker
 
 import (
+	"flag"
 	"fmt"
-	"os"
-	"sort"
 	"text/tabwriter"
 
 	"nodepacker/filter"
 	"nodepacker/types"
-	"github.com/dustin/go-humanize"
 )
 
-func showPodsCommand(cctx *CommandContext, args []string) {
+// showPodsOptions are the flags of pods_show
+type showPodsOptions struct {
+	output string
+}
+
+func (o *showPodsOptions) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("pods_show", flag.ContinueOnError)
+	outputFlag(fs, &o.output)
+	return fs
+}
+
+func showPodsCommand(cctx *CommandContext, args []string) error {
 	pods := cctx.pods
 
-	mf, err := filter.Create(args)
+	var o showPodsOptions
+	fs := o.flagSet()
+
+	err := parseFlags(cctx, fs, args)
 	if err != nil {
-		fmt.Println("failed to build filter", err)
-		mf = &types.NoopResourceFilter{}
+		return err
+	}
+	format, err := cctx.outputFormat(o.output)
+	if err != nil {
+		return err
 	}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.AlignRight)
+	mf, err := filter.Create(fs.Args(), cctx.quotedArgs(fs.Args()))
+	if err != nil {
+		return argsErrorf("failed to build filter: %v", err)
+	}
 
-	podKeys := make([]string, 0, len(pods))
-	for k := range pods {
-		podKeys = append(podKeys, k)
+	podKeys := sortedPodNames(pods, mf)
+	if format != outputTable {
+		return writePods(cctx.out, format, newPodRecords(cctx, podKeys))
 	}
 
-	sort.Strings(podKeys)
+	w := tabwriter.NewWriter(cctx.out, 0, 0, 3, ' ', tabwriter.AlignRight)
 
 	for _, k := range podKeys {
 		v := pods[k]
-		if mf.Pass(v) {
-			mem := humanize.Ftoa(float64(v.Memory) / 1000.0)
-			cpu := humanize.Ftoa(float64(v.CPU) / 1000.0)
+		mem := types.FormatMemory(v.Memory, cctx.config.Units)
+		cpu := types.FormatCPU(v.CPU)
 
-			_, _ = fmt.Fprintf(w, "%s\t%s\t%s GB\t\n", k, cpu, mem)
+		placement := ""
+		if node := nodeOf(cctx.nodes, k); node != nil {
+			placement = node.name
 		}
+		if pin, ok := cctx.pins[k]; ok {
+			placement += " (pinned to " + pin + ")"
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s %s\t%s\t\n", k, cpu, mem, cctx.config.Units, placement)
 	}
 	_ = w.Flush()
 
 	totalRes := types.SumResourceMap(pods)
-	mem := humanize.Ftoa(float64(totalRes.Memory) / 1000.0)
-	cpu := humanize.Ftoa(float64(totalRes.CPU) / 1000.0)
+	mem := types.FormatMemory(totalRes.Memory, cctx.config.Units)
+	cpu := types.FormatCPU(totalRes.CPU)
+
+	cctx.printf("\ntotal CPU: %s, total mem: %s %s\n", cpu, mem, cctx.config.Units)
+	return nil
+}
+
+// movePod moves the pod to the named node of the current cluster, updating the free space of
+// both nodes. Overcommitting the target node is allowed but reported.
+func movePod(cctx *CommandContext, podName, nodeName string) error {
+	pod, ok := cctx.pods[podName]
+	if !ok {
+		return notFoundErrorf("unknown pod %s", podName)
+	}
+	_, target := findNode(cctx.nodes, nodeName)
+	if target == nil {
+		return notFoundErrorf("unknown node %s", nodeName)
+	}
+
+	if from := nodeOf(cctx.nodes, podName); from != nil {
+		if from == target {
+			return nil
+		}
+		from.remove(pod)
+		cctx.printf("moved %s from %s to %s\n", podName, from.name, target.name)
+	} else {
+		cctx.printf("placed %s on %s\n", podName, target.name)
+	}
+	target.assign(pod)
+
+	if over, ok := target.overcommitted(); ok {
+		cctx.infof("warning: %s does not fit, %s is overcommitted by %s\n", podName, target.name, over.String())
+	}
+	cctx.printf("free on %s: %s\n", target.name, target.free.FormatMachine(cctx.config.Units))
+	return nil
+}
 
-	fmt.Printf("\ntotal CPU: %s, total mem: %s\n", cpu, mem)
+// pods_pin <pod> <node>
+func pinPodCommand(cctx *CommandContext, args []string) error {
+	if len(args) != 2 {
+		return argsErrorf("expected a pod and a node")
+	}
+
+	err := movePod(cctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if cctx.pins == nil {
+		cctx.pins = make(map[string]string)
+	}
+	cctx.pins[args[0]] = args[1]
+	cctx.printf("pinned %s to %s\n", args[0], args[1])
+	return nil
+}
+
+// pods_move <pod> <node>
+func movePodCommand(cctx *CommandContext, args []string) error {
+	if len(args) != 2 {
+		return argsErrorf("expected a pod and a node")
+	}
+
+	err := movePod(cctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if pin, ok := cctx.pins[args[0]]; ok && pin != args[1] {
+		cctx.infof("note: %s is pinned to %s, nodes_pack will move it back\n", args[0], pin)
+	}
+	return nil
+}
+
+// pods_unpin <pod>... or pods_unpin -all
+func unpinPodCommand(cctx *CommandContext, args []string) error {
+	if len(args) == 0 {
+		return argsErrorf("expected one or more pods or -all")
+	}
+
+	if len(args) == 1 && args[0] == "-all" {
+		cctx.pins = nil
+		cctx.println("removed all pins")
+		return nil
+	}
+
+	for _, pod := range args {
+		if _, ok := cctx.pins[pod]; !ok {
+			return notFoundErrorf("pod is not pinned: %s", pod)
+		}
+	}
+	for _, pod := range args {
+		delete(cctx.pins, pod)
+		cctx.println("unpinned", pod)
+	}
+	return nil
 }
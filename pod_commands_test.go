@@ -0,0 +1,158 @@
+package nodepacker
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"nodepacker/types"
+)
+
+func TestPinMoveUnpin(t *testing.T) {
+	crh, out := newClusterTestHandler(t)
+
+	err := crh.Execute("nodes_add small 2; pods_pin a node-1; pods_move b node-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(crh.cctx.pins, map[string]string{"a": "node-1"}) {
+		t.Errorf("unexpected pins %v", crh.cctx.pins)
+	}
+	if !reflect.DeepEqual(crh.cctx.nodes[0].pods, []string{"b"}) || !reflect.DeepEqual(crh.cctx.nodes[1].pods, []string{"a"}) {
+		t.Errorf("unexpected pods %v and %v", crh.cctx.nodes[0].pods, crh.cctx.nodes[1].pods)
+	}
+
+	out.Reset()
+	err = crh.Execute("pods_move a node-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"moved a from node-1 to node-0", "warning: a does not fit, node-0 is overcommitted",
+		"note: a is pinned to node-1"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in\n%s", want, out.String())
+		}
+	}
+	if n := crh.cctx.nodes[1]; len(n.pods) != 0 || n.free.CPU != n.machine.CPU || n.free.Memory != n.machine.Memory {
+		t.Errorf("expected node-1 to be empty, got %v, free %v", n.pods, n.free)
+	}
+
+	tests := []struct {
+		statement string
+		kind      error
+	}{
+		{"pods_pin a", ErrInvalidArgs},
+		{"pods_pin x node-0", ErrNotFound},
+		{"pods_pin a node-9", ErrNotFound},
+		{"pods_unpin", ErrInvalidArgs},
+		{"pods_unpin a b", ErrNotFound},
+	}
+	for _, test := range tests {
+		err := crh.Execute(test.statement)
+		if !errors.Is(err, test.kind) {
+			t.Errorf("%s: expected error kind %v, got %v", test.statement, test.kind, err)
+		}
+	}
+	if !reflect.DeepEqual(crh.cctx.pins, map[string]string{"a": "node-1"}) {
+		t.Errorf("failed commands changed the pins: %v", crh.cctx.pins)
+	}
+
+	err = crh.Execute("pods_pin b node-0; pods_unpin a; pods_unpin -all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crh.cctx.pins) != 0 {
+		t.Errorf("expected no pins, got %v", crh.cctx.pins)
+	}
+}
+
+func TestPackPinned(t *testing.T) {
+	crh, out := newClusterTestHandler(t)
+
+	// pinning both a and b to node-0 overcommits it, c is pinned to a node that's removed
+	err := crh.Execute("nodes_add small 3; pods_pin a node-0; pods_pin b node-0; pods_pin c node-2; nodes_remove node-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	err = crh.Execute("nodes_pack -existing -no-grow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"warning: pinning b overcommits node-0 by", "warning: c is pinned to unknown node node-2"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in\n%s", want, out.String())
+		}
+	}
+	if !reflect.DeepEqual(crh.cctx.nodes[0].pods, []string{"a", "b"}) || !reflect.DeepEqual(crh.cctx.nodes[1].pods, []string{"c"}) {
+		t.Errorf("unexpected pods %v and %v", crh.cctx.nodes[0].pods, crh.cctx.nodes[1].pods)
+	}
+
+	// growing adds the node-<n> nodes pins refer to
+	out.Reset()
+	err = crh.Execute("nodes_pack -existing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "unknown node") {
+		t.Errorf("expected node-2 to be added, got\n%s", out.String())
+	}
+	if _, node := findNode(crh.cctx.nodes, "node-2"); node == nil || !reflect.DeepEqual(node.pods, []string{"c"}) {
+		t.Errorf("expected c on node-2, got %v", nodeNames(crh.cctx.nodes))
+	}
+}
+
+func TestMemoryUnits(t *testing.T) {
+	crh, out := newClusterTestHandler(t)
+	other, otherOut := newClusterTestHandler(t)
+	crh.cctx.config.Units = types.UnitGiB
+
+	// the catalogs store a GiB of machine memory as 1000 MB, pods store MB of 10^6 bytes
+	err := crh.Execute("nodes_add small; pods_show")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "added node-0 {small, cpu: 2, mem: 4 GiB}") {
+		t.Errorf("expected the machine memory in GiB, got %q", out.String())
+	}
+	if !strings.Contains(out.String(), "total CPU: 4.5, total mem: 8.38 GiB") {
+		t.Errorf("expected the pod memory in GiB, got %q", out.String())
+	}
+
+	err = other.Execute("nodes_add small; pods_show")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(otherOut.String(), "added node-0 {small, cpu: 2, mem: 4.29 GB}") {
+		t.Errorf("expected the machine memory converted to GB, got %q", otherOut.String())
+	}
+	if !strings.Contains(otherOut.String(), "total CPU: 4.5, total mem: 9 GB") {
+		t.Errorf("expected the units of another handler to be unchanged, got %q", otherOut.String())
+	}
+}
+
+func TestShowPodsQuotedValues(t *testing.T) {
+	crh, out := newClusterTestHandler(t)
+	crh.cctx.pods = map[string]types.Resource{
+		"cpu": {Name: "cpu", CPU: 500, Memory: 1000},
+		"8":   {Name: "8", CPU: 8000, Memory: 1000},
+	}
+
+	// quoted values are compared as strings even if they look like fields or numbers
+	err := crh.Execute("pods_show -o csv name = 'cpu'; pods_show -o csv name = \"8\" & cpu = 8; alias pn pods_show -o csv name = $1; pn 'cpu'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "name,workload,kind,cpuMillis,memoryMB,storageMB,node,pinnedTo\ncpu,,,500,1000,0,,\n" +
+		"name,workload,kind,cpuMillis,memoryMB,storageMB,node,pinnedTo\n8,,,8000,1000,0,,\n" +
+		"name,workload,kind,cpuMillis,memoryMB,storageMB,node,pinnedTo\ncpu,,,500,1000,0,,\n"
+	if out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+
+	if err := crh.Execute("pods_show name = cpu"); !errors.Is(err, ErrInvalidArgs) {
+		t.Errorf("expected an unquoted value to fail with ErrInvalidArgs, got %v", err)
+	}
+}
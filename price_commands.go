@@ -0,0 +1,144 @@
+package nodepacker
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"nodepacker/types"
+	"github.com/dustin/go-humanize"
+	"gopkg.in/yaml.v3"
+)
+
+// loadPrices reads a price catalog. The file has zones as keys, for example
+//
+//	us-central1-a:
+//	  disk: 0.04
+//	  machines:
+//	    n1-standard-16:
+//	      onDemand: 0.76
+//	      spot: 0.16
+//	      commit1y: 0.478
+//	      commit3y: 0.342
+//
+// Machine prices are USD per hour, disk prices USD per GB and month.
+func loadPrices(path string) (types.Prices, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var prices types.Prices
+
+	d := yaml.NewDecoder(bufio.NewReader(f))
+	err = d.Decode(&prices)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode price catalog %s: %v", path, err)
+	}
+	return prices, nil
+}
+
+// savePrices saves the prices in prices.yaml in the cache directory
+func savePrices(dir string, prices types.Prices) error {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, "prices.yaml"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	bf := bufio.NewWriter(f)
+	defer bf.Flush()
+
+	e := yaml.NewEncoder(bf)
+	return e.Encode(prices)
+}
+
+func readPrices(dir string) (types.Prices, error) {
+	return loadPrices(filepath.Join(dir, "prices.yaml"))
+}
+
+func loadPricesCommand(cctx *CommandContext, args []string) error {
+	if len(args) == 0 {
+		return argsErrorf("expected one or more price catalog files")
+	}
+
+	prices := make(types.Prices)
+	prices.Merge(cctx.prices)
+	for _, path := range args {
+		ps, err := loadPrices(path)
+		if err != nil {
+			return fmt.Errorf("failed to load prices: %w", err)
+		}
+		prices.Merge(ps)
+	}
+
+	cctx.prices = prices
+	cctx.infoln("got the prices")
+	err := savePrices(cctx.config.CacheDir, prices)
+	if err != nil {
+		cctx.infoln("warning: failed to save prices in", cctx.config.CacheDir+":", err)
+	}
+	return nil
+}
+
+// planCost is the estimated cost of a plan in USD
+type planCost struct {
+	model string
+	// hourly price of all nodes
+	hourly  float64
+	nodes   float64
+	disk    float64
+	storage int64
+}
+
+func (pc *planCost) monthly() float64 {
+	return pc.nodes + pc.disk
+}
+
+func (pc *planCost) annual() float64 {
+	return pc.monthly() * 12
+}
+
+// costOf estimates the monthly cost of running the plan with the given pricing model.
+// It returns nil if the zone has no price for one of the plan's machine types.
+func costOf(plan *packPlan, pods map[string]types.Resource, zp types.ZonePrices, model string) (*planCost, error) {
+	if _, err := (types.Price{}).Hourly(model); err != nil {
+		return nil, err
+	}
+
+	var hourly float64
+	for _, node := range plan.nodes {
+		price, ok := zp.Machines[node.machine.Name]
+		if !ok {
+			return nil, nil
+		}
+		h, _ := price.Hourly(model)
+		hourly += h
+	}
+
+	storage := types.SumResourceMap(pods).Storage
+	return &planCost{
+		model:   model,
+		hourly:  hourly,
+		nodes:   hourly * types.HoursPerMonth,
+		disk:    zp.Disk * float64(storage) / 1000.0,
+		storage: storage,
+	}, nil
+}
+
+func printCost(cctx *CommandContext, pc *planCost, numNodes int) {
+	if pc == nil {
+		cctx.println("no price known for this machine type, load prices with prices_load")
+		return
+	}
+	cctx.printf("estimated cost (%s): $%.2f/month, $%.2f/year\n", pc.model, pc.monthly(), pc.annual())
+	cctx.printf("  nodes: %d, $%.4f/h = $%.2f/month\n", numNodes, pc.hourly, pc.nodes)
+	cctx.printf("  disk: %s GB = $%.2f/month\n", humanize.Ftoa(float64(pc.storage)/1000.0), pc.disk)
+}
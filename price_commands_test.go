@@ -0,0 +1,85 @@
+package nodepacker
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"nodepacker/types"
+)
+
+func TestCostOf(t *testing.T) {
+	m := types.Resource{Name: "n1-standard-4", CPU: 4000, Memory: 15000}
+	plan := &packPlan{machine: m, nodes: []*clusterNode{newClusterNode("node-0", m, nil), newClusterNode("node-1", m, nil)}}
+	pods := map[string]types.Resource{
+		"pgsql-0":     {Name: "pgsql-0", CPU: 1000, Memory: 2000, Storage: 80000},
+		"gitserver-0": {Name: "gitserver-0", CPU: 1000, Memory: 2000, Storage: 20000},
+	}
+	zp := types.ZonePrices{
+		Disk:     0.04,
+		Machines: map[string]types.Price{"n1-standard-4": {OnDemand: 0.2, Spot: 0.05, Commit1Y: 0.12, Commit3Y: 0.08}},
+	}
+
+	tests := []struct {
+		model   string
+		hourly  float64
+		monthly float64
+	}{
+		// 100 GB of disk for $4 a month
+		{types.PricingOnDemand, 0.4, 0.4*730 + 4},
+		{types.PricingSpot, 0.1, 0.1*730 + 4},
+		{types.Pricing1Y, 0.24, 0.24*730 + 4},
+		{types.Pricing3Y, 0.16, 0.16*730 + 4},
+	}
+	for _, test := range tests {
+		pc, err := costOf(plan, pods, zp, test.model)
+		if err != nil {
+			t.Fatal(test.model, err)
+		}
+		if math.Abs(pc.hourly-test.hourly) > 1e-9 || math.Abs(pc.monthly()-test.monthly) > 1e-9 {
+			t.Errorf("%s: expected $%.4f/h and $%.2f/month, got %+v", test.model, test.hourly, test.monthly, pc)
+		}
+		if math.Abs(pc.annual()-12*test.monthly) > 1e-9 {
+			t.Errorf("%s: expected $%.2f/year, got $%.2f", test.model, 12*test.monthly, pc.annual())
+		}
+	}
+
+	pc, err := costOf(plan, pods, types.ZonePrices{Disk: 0.04}, types.PricingOnDemand)
+	if err != nil || pc != nil {
+		t.Errorf("expected no cost without a machine price, got %+v, %v", pc, err)
+	}
+	if _, err := costOf(plan, pods, zp, "monthly"); err == nil {
+		t.Error("expected an unknown pricing model to fail")
+	}
+}
+
+func TestPrintSavings(t *testing.T) {
+	crh := newTestHandler(t)
+	var out bytes.Buffer
+	crh.SetOutput(&out, &out)
+
+	m := types.Resource{Name: "n1-standard-4", CPU: 4000, Memory: 15000}
+	plan := func(n int, monthly float64) *packPlan {
+		p := &packPlan{machine: m, cost: &planCost{nodes: monthly}}
+		for i := 0; i < n; i++ {
+			p.nodes = append(p.nodes, newClusterNode("node", m, nil))
+		}
+		return p
+	}
+
+	printSavings(crh.cctx, []*packPlan{plan(1, 100), plan(2, 50)}, plan(4, 200))
+	want := "tiered plan: 3 nodes, $150.00/month, $1800.00/year\n" +
+		"all on-demand plan: 4 nodes of n1-standard-4, $200.00/month, $2400.00/year\n" +
+		"savings: $50.00/month, $600.00/year (25.0%)\n"
+	if out.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out.String())
+	}
+
+	out.Reset()
+	unpriced := plan(2, 0)
+	unpriced.cost = nil
+	printSavings(crh.cctx, []*packPlan{plan(1, 100), unpriced}, plan(4, 200))
+	if out.String() != "missing prices, cannot compare with all on-demand plan\n" {
+		t.Errorf("unexpected output without prices: %s", out.String())
+	}
+}
@@ -0,0 +1,466 @@
+package nodepacker
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"nodepacker/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Provider is a cloud provider machine types and prices come from. Zones are the zones of the
+// provider, or its regions if machine types aren't listed per zone.
+type Provider interface {
+	// Name is the name nodepacker knows the provider by, for example gcp
+	Name() string
+	// DefaultZone is the zone nodepacker starts in if none is configured
+	DefaultZone() string
+	// Machines lists the current machine types by zone
+	Machines() (types.Machines, error)
+	// Catalog returns the machine types and prices of the built-in catalog and its version
+	Catalog() (types.Machines, types.Prices, string, error)
+	// Allocatable returns the resources of a machine left for pods after the provider's
+	// reservations for the kubelet, the system and eviction
+	Allocatable(m types.Resource) types.Resource
+	// Describe fills in the attributes of a machine type that aren't known from its name and
+	// resources
+	Describe(m types.Resource) types.Resource
+}
+
+// customMachineProvider is a provider with custom machine types, machine types of a family with
+// the vCPUs and memory chosen freely
+type customMachineProvider interface {
+	// CustomMachine returns a custom machine type by name if its family is available in the zone
+	// of ms
+	CustomMachine(name string, ms map[string]types.Resource) (types.Resource, bool)
+	// CustomShapes returns custom machine types shaped for the pods of the families available in
+	// the zone of ms
+	CustomShapes(pods map[string]types.Resource, h headroom, ms map[string]types.Resource) []types.Resource
+}
+
+// providers by name, the first one is the default
+var providers = []Provider{
+	gcpProvider{builtinProvider: builtinProvider{name: "gcp", defaultZone: "us-central1-a", catalog: gceCatalog}},
+	awsProvider{builtinProvider{name: "aws", defaultZone: "us-east-1a", catalog: awsCatalog}},
+	azureProvider{builtinProvider{name: "azure", defaultZone: "eastus", catalog: azureCatalog}},
+}
+
+func providerNames() []string {
+	names := make([]string, 0, len(providers))
+	for _, p := range providers {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+func lookupProvider(name string) (Provider, error) {
+	for _, p := range providers {
+		if p.Name() == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown provider %s, expected one of %s", name, strings.Join(providerNames(), ", "))
+}
+
+// builtinProvider implements the parts of Provider backed by a built-in catalog
+type builtinProvider struct {
+	name        string
+	defaultZone string
+	catalog     []byte
+}
+
+func (bp builtinProvider) Name() string {
+	return bp.name
+}
+
+func (bp builtinProvider) DefaultZone() string {
+	return bp.defaultZone
+}
+
+func (bp builtinProvider) Catalog() (types.Machines, types.Prices, string, error) {
+	var mc machineCatalog
+	err := yaml.Unmarshal(bp.catalog, &mc)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to decode the built-in %s catalog: %v", bp.name, err)
+	}
+	machines, err := mc.machines()
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("invalid built-in %s catalog: %v", bp.name, err)
+	}
+	return machines, mc.prices(), mc.Version, nil
+}
+
+// Machines of providers without an API nodepacker can query are those of the built-in catalog
+func (bp builtinProvider) Machines() (types.Machines, error) {
+	machines, _, _, err := bp.Catalog()
+	return machines, err
+}
+
+// reservationTier reserves a fraction of the part of a resource up to limit that exceeds the previous tier
+type reservationTier struct {
+	limit    int64
+	fraction float64
+}
+
+// reserved sums the tiers of a resource, the limit of the last tier has to be math.MaxInt64
+func reserved(amount int64, tiers []reservationTier) int64 {
+	var sum float64
+	var lower int64
+	for _, t := range tiers {
+		if amount <= lower {
+			break
+		}
+		upper := amount
+		if upper > t.limit {
+			upper = t.limit
+		}
+		sum += float64(upper-lower) * t.fraction
+		lower = t.limit
+	}
+	return int64(sum)
+}
+
+// cpuTiers reserve 6% of the first core, 1% of the second, 0.5% of the next two and 0.25% of
+// the rest, as GKE and EKS do
+var cpuTiers = []reservationTier{{1000, 0.06}, {2000, 0.01}, {4000, 0.005}, {math.MaxInt64, 0.0025}}
+
+// memoryTiers reserve 25% of the first 4 GB, 20% of the next 4 GB, 10% of the next 8 GB, 6% of
+// the next 112 GB and 2% of the rest, as GKE and AKS do
+var memoryTiers = []reservationTier{{4000, 0.25}, {8000, 0.2}, {16000, 0.1}, {128000, 0.06}, {math.MaxInt64, 0.02}}
+
+// allocatable subtracts reservations from a machine, it never returns negative resources
+func allocatable(m types.Resource, cpu, memory int64) types.Resource {
+	a := m
+	a.CPU = m.CPU - cpu
+	a.Memory = m.Memory - memory
+	if a.CPU < 0 {
+		a.CPU = 0
+	}
+	if a.Memory < 0 {
+		a.Memory = 0
+	}
+	return a
+}
+
+// describeMachines fills in the attributes of the machine types of a provider
+func describeMachines(p Provider, machines types.Machines) {
+	for _, ms := range machines {
+		for name, m := range ms {
+			ms[name] = p.Describe(m)
+		}
+	}
+}
+
+// generation returns the first number in a family name, 1 if there is none
+func generation(family string) int {
+	digits := strings.TrimLeft(family, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	n := 0
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			break
+		}
+		n = n*10 + int(r-'0')
+	}
+	if n == 0 {
+		return 1
+	}
+	return n
+}
+
+// allocatableMachines returns the machines with their allocatable resources
+func allocatableMachines(p Provider, ms map[string]types.Resource) map[string]types.Resource {
+	as := make(map[string]types.Resource, len(ms))
+	for name, m := range ms {
+		as[name] = p.Allocatable(m)
+	}
+	return as
+}
+
+type gcpProvider struct {
+	builtinProvider
+	// runs gcloud, execCommand if nil
+	run commandRunner
+}
+
+// Machines lists the machine types of all zones with gcloud
+func (gp gcpProvider) Machines() (types.Machines, error) {
+	run := gp.run
+	if run == nil {
+		run = execCommand
+	}
+	return availableMachines(run)
+}
+
+// Allocatable follows the GKE reservations: machines with less than 1 GB keep 255 MB, others
+// memoryTiers, plus 100 MB for eviction
+func (gcpProvider) Allocatable(m types.Resource) types.Resource {
+	memory := int64(255)
+	if m.Memory >= 1000 {
+		memory = reserved(m.Memory, memoryTiers)
+	}
+	return allocatable(m, reserved(m.CPU, cpuTiers), memory+100)
+}
+
+// gceLocalSSDFamilies are the GCE families local SSDs can be attached to
+var gceLocalSSDFamilies = map[string]bool{"n1": true, "n2": true, "n2d": true, "c2": true, "a2": true, "m1": true}
+
+// gceNetworkCaps are the maximum egress bandwidths of GCE families in Gbps, families not listed have
+// 16 Gbps
+var gceNetworkCaps = map[string]int64{"n2": 32, "n2d": 32, "c2": 32}
+
+// Describe derives the family from the name and the network bandwidth from the vCPUs, 2 Gbps per
+// vCPU up to the cap of the family and 1 Gbps for shared-core machine types
+func (gcpProvider) Describe(m types.Resource) types.Resource {
+	return describeGCE(m)
+}
+
+// CustomMachine returns a GCE custom machine type, see customMachine
+func (gcpProvider) CustomMachine(name string, ms map[string]types.Resource) (types.Resource, bool) {
+	return customMachine(name, ms)
+}
+
+// CustomShapes returns GCE custom machine types shaped for the pods, see customShapes
+func (gcpProvider) CustomShapes(pods map[string]types.Resource, h headroom, ms map[string]types.Resource) []types.Resource {
+	return customShapes(pods, h, ms)
+}
+
+func describeGCE(m types.Resource) types.Resource {
+	mi := &m.Machine
+	if mi.Family == "" {
+		mi.Family = strings.SplitN(m.Name, "-", 2)[0]
+	}
+	if mi.Generation == 0 {
+		mi.Generation = generation(mi.Family)
+	}
+	if mi.Arch == "" {
+		mi.Arch = types.ArchX86
+		if strings.HasPrefix(mi.Family, "t2a") {
+			mi.Arch = types.ArchARM64
+		}
+	}
+	mi.LocalSSD = mi.LocalSSD || gceLocalSSDFamilies[mi.Family]
+	if mi.MaxPersistentDisks == 0 {
+		mi.MaxPersistentDisks = 128
+		if mi.SharedCPU {
+			mi.MaxPersistentDisks = 16
+		}
+	}
+	if mi.NetworkMbps == 0 {
+		limit, ok := gceNetworkCaps[mi.Family]
+		if !ok {
+			limit = 16
+		}
+		gbps := 2 * m.CPU / 1000
+		if gbps > limit {
+			gbps = limit
+		}
+		if mi.SharedCPU || gbps < 1 {
+			gbps = 1
+		}
+		mi.NetworkMbps = gbps * 1000
+	}
+	return m
+}
+
+type awsProvider struct {
+	builtinProvider
+}
+
+// awsMaxPods approximates the number of pods EKS allows on a node by its vCPUs, the actual limit
+// depends on the network interfaces of the instance type
+func awsMaxPods(cpu int64) int64 {
+	switch {
+	case cpu <= 2000:
+		return 29
+	case cpu <= 8000:
+		return 58
+	}
+	return 110
+}
+
+// Allocatable follows the EKS reservations: 255 MB plus 11 MB per pod the node can run, plus
+// 100 MB for eviction
+func (awsProvider) Allocatable(m types.Resource) types.Resource {
+	memory := 255 + 11*awsMaxPods(m.CPU)
+	return allocatable(m, reserved(m.CPU, cpuTiers), memory+100)
+}
+
+// Describe derives the family and its attributes from the name, m6gd.large is of family m6gd,
+// generation 6, an arm64 machine (g) with local SSDs (d). The burstable t families share their
+// CPUs. The network bandwidth comes from the catalog.
+func (awsProvider) Describe(m types.Resource) types.Resource {
+	mi := &m.Machine
+	if mi.Family == "" {
+		mi.Family = strings.SplitN(m.Name, ".", 2)[0]
+	}
+	if mi.Generation == 0 {
+		mi.Generation = generation(mi.Family)
+	}
+	// the attributes follow the generation
+	attributes := strings.TrimLeft(mi.Family, "abcdefghijklmnopqrstuvwxyz")
+	attributes = strings.TrimLeft(attributes, "0123456789")
+	if mi.Arch == "" {
+		mi.Arch = types.ArchX86
+		if strings.Contains(attributes, "g") {
+			mi.Arch = types.ArchARM64
+		}
+	}
+	mi.SharedCPU = mi.SharedCPU || strings.HasPrefix(mi.Family, "t")
+	mi.LocalSSD = mi.LocalSSD || strings.Contains(attributes, "d")
+	if mi.MaxPersistentDisks == 0 {
+		// EBS volume limit of Nitro instances
+		mi.MaxPersistentDisks = 28
+	}
+	return m
+}
+
+type azureProvider struct {
+	builtinProvider
+}
+
+// azureCPUReserved are the AKS CPU reservations in millicores by the number of cores of a node
+var azureCPUReserved = []struct {
+	cores    int64
+	reserved int64
+}{{1, 60}, {2, 100}, {4, 140}, {8, 180}, {16, 260}, {32, 420}, {64, 740}}
+
+// Allocatable follows the AKS reservations: CPU by azureCPUReserved, memoryTiers plus 750 MB for
+// eviction
+func (azureProvider) Allocatable(m types.Resource) types.Resource {
+	cpu := azureCPUReserved[len(azureCPUReserved)-1].reserved
+	for _, r := range azureCPUReserved {
+		if m.CPU <= r.cores*1000 {
+			cpu = r.reserved
+			break
+		}
+	}
+	return allocatable(m, cpu, reserved(m.Memory, memoryTiers)+750)
+}
+
+// azureSize matches Azure VM size names, Standard_D4ds_v4 is of series D, has the attributes ds
+// and is of version 4
+var azureSize = regexp.MustCompile(`^Standard_([A-Z]+)[0-9]+(?:-[0-9]+)?([a-z]*)(?:_v([0-9]+))?$`)
+
+// Describe derives the family and its attributes from the name, Standard_D4ds_v4 is of family
+// Ddsv4 and generation 4 with local SSDs (d). Sizes before version 4 all have a local SSD, p marks
+// arm64 sizes and the burstable B series shares its CPUs. The network bandwidth comes from the
+// catalog.
+func (azureProvider) Describe(m types.Resource) types.Resource {
+	mi := &m.Machine
+	match := azureSize.FindStringSubmatch(m.Name)
+	if match == nil {
+		return m
+	}
+	version := 1
+	if match[3] != "" {
+		version, _ = strconv.Atoi(match[3])
+	}
+	if mi.Family == "" {
+		mi.Family = match[1] + match[2]
+		if match[3] != "" {
+			mi.Family += "v" + match[3]
+		}
+	}
+	if mi.Generation == 0 {
+		mi.Generation = version
+	}
+	if mi.Arch == "" {
+		mi.Arch = types.ArchX86
+		if strings.Contains(match[2], "p") {
+			mi.Arch = types.ArchARM64
+		}
+	}
+	mi.SharedCPU = mi.SharedCPU || match[1] == "B"
+	mi.LocalSSD = mi.LocalSSD || version < 4 || strings.Contains(match[2], "d")
+	if mi.MaxPersistentDisks == 0 {
+		// two data disks per vCPU, up to 32
+		mi.MaxPersistentDisks = int(2 * m.CPU / 1000)
+		if mi.MaxPersistentDisks > 32 {
+			mi.MaxPersistentDisks = 32
+		}
+	}
+	return m
+}
+
+// loadProviderMachines returns the cached machines of a provider, or those of its built-in catalog
+// and a warning if there are none
+func loadProviderMachines(dir string, p Provider) (types.Machines, string, error) {
+	machines, err := readMachines(dir, p.Name())
+	if err == nil {
+		describeMachines(p, machines)
+		return machines, "", nil
+	}
+	machines, _, version, err := p.Catalog()
+	if err != nil {
+		return nil, "", err
+	}
+	describeMachines(p, machines)
+	return machines, fmt.Sprintf("couldn't read %s machines from %s, using the built-in catalog %s. "+
+		"execute command machines_fetch for the current machine types", p.Name(), dir, version), nil
+}
+
+// addCatalogPrices adds the prices of the built-in catalog of a provider to the zones and machine
+// types without loaded prices
+func addCatalogPrices(prices types.Prices, p Provider) (types.Prices, error) {
+	_, cps, _, err := p.Catalog()
+	if err != nil {
+		return prices, err
+	}
+	if cps == nil {
+		return prices, nil
+	}
+	cps.Merge(prices)
+	return cps, nil
+}
+
+// machines_provider [provider]
+func providerCommand(cctx *CommandContext, args []string) error {
+	if len(args) == 0 {
+		cctx.println(cctx.provider.Name())
+		return nil
+	}
+	if len(args) > 1 {
+		return argsErrorf("expected no args to get the current provider or one argument to set it")
+	}
+
+	p, err := lookupProvider(args[0])
+	if err != nil {
+		return notFoundErrorf("%v", err)
+	}
+	machines, warning, err := loadProviderMachines(cctx.config.CacheDir, p)
+	if err != nil {
+		return err
+	}
+	prices, err := addCatalogPrices(cctx.prices, p)
+	if err != nil {
+		return err
+	}
+	if warning != "" {
+		cctx.infoln(warning)
+	}
+
+	cctx.provider = p
+	cctx.machines = machines
+	cctx.prices = prices
+	if _, ok := machines[cctx.zone]; !ok {
+		cctx.zone = p.DefaultZone()
+		if _, ok := machines[cctx.zone]; !ok && len(machines) > 0 {
+			cctx.zone = sortedZones(machines)[0]
+		}
+	}
+	cctx.infof("set current provider to %s, zone %s\n", p.Name(), cctx.zone)
+	return nil
+}
+
+func sortedZones(machines types.Machines) []string {
+	zones := make([]string, 0, len(machines))
+	for zone := range machines {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	return zones
+}
@@ -0,0 +1,118 @@
+package nodepacker
+
+import (
+	"bytes"
+	"testing"
+
+	"nodepacker/types"
+)
+
+func TestProviderCatalogs(t *testing.T) {
+	tests := []struct {
+		provider string
+		machine  string
+		cpu      int64
+		memory   int64
+		priced   bool
+	}{
+		{"gcp", "n1-standard-4", 4000, 15000, false},
+		{"aws", "m5.xlarge", 4000, 16000, true},
+		{"azure", "Standard_D4s_v3", 4000, 16000, true},
+	}
+	for _, test := range tests {
+		p, err := lookupProvider(test.provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		machines, prices, version, err := p.Catalog()
+		if err != nil {
+			t.Fatalf("%s: %v", test.provider, err)
+		}
+		if version == "" {
+			t.Errorf("%s: expected a catalog version", test.provider)
+		}
+		m, ok := machines[p.DefaultZone()][test.machine]
+		if !ok {
+			t.Fatalf("%s: expected %s in %s", test.provider, test.machine, p.DefaultZone())
+		}
+		if m.CPU != test.cpu || m.Memory != test.memory {
+			t.Errorf("%s: unexpected %s %+v", test.provider, test.machine, m)
+		}
+		_, ok = prices[p.DefaultZone()].Machines[test.machine]
+		if ok != test.priced {
+			t.Errorf("%s: expected price of %s %v, got %v", test.provider, test.machine, test.priced, ok)
+		}
+
+		a := p.Allocatable(m)
+		if a.CPU <= 0 || a.CPU >= m.CPU || a.Memory <= 0 || a.Memory >= m.Memory {
+			t.Errorf("%s: unexpected allocatable %+v of %+v", test.provider, a, m)
+		}
+	}
+}
+
+func TestGCPAllocatable(t *testing.T) {
+	p, _ := lookupProvider("gcp")
+	// 4 vCPU and 15 GB: 60+10+10 millicores, 1000+800+700 MB plus 100 MB eviction
+	a := p.Allocatable(types.Resource{Name: "n1-standard-4", CPU: 4000, Memory: 15000})
+	if a.CPU != 3920 || a.Memory != 12400 {
+		t.Errorf("unexpected allocatable %+v", a)
+	}
+}
+
+func TestDescribeMachines(t *testing.T) {
+	tests := []struct {
+		provider string
+		machine  types.Resource
+		want     types.MachineInfo
+	}{
+		{"gcp", types.Resource{Name: "n2-standard-32", CPU: 32000, Memory: 128000},
+			types.MachineInfo{Family: "n2", Generation: 2, Arch: types.ArchX86, LocalSSD: true,
+				MaxPersistentDisks: 128, NetworkMbps: 32000}},
+		{"aws", types.Resource{Name: "m6gd.large", CPU: 2000, Memory: 8000},
+			types.MachineInfo{Family: "m6gd", Generation: 6, Arch: types.ArchARM64, LocalSSD: true,
+				MaxPersistentDisks: 28}},
+		{"azure", types.Resource{Name: "Standard_D4s_v3", CPU: 4000, Memory: 16000},
+			types.MachineInfo{Family: "Dsv3", Generation: 3, Arch: types.ArchX86, LocalSSD: true,
+				MaxPersistentDisks: 8}},
+	}
+	for _, test := range tests {
+		p, _ := lookupProvider(test.provider)
+		got := p.Describe(test.machine).Machine
+		if got != test.want {
+			t.Errorf("%s: expected %+v, got %+v", test.machine.Name, test.want, got)
+		}
+	}
+}
+
+func TestProviderCommand(t *testing.T) {
+	crh := newTestHandler(t)
+	var out bytes.Buffer
+	crh.SetOutput(&out, &out)
+
+	err := crh.Execute("machines_provider aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crh.cctx.provider.Name() != "aws" || crh.cctx.zone != "us-east-1a" {
+		t.Errorf("expected provider aws in us-east-1a, got %s in %s", crh.cctx.provider.Name(), crh.cctx.zone)
+	}
+	if _, ok := crh.cctx.machines[crh.cctx.zone]["m5.large"]; !ok {
+		t.Error("expected the machines of the aws catalog")
+	}
+	if _, ok := crh.cctx.prices[crh.cctx.zone].Machines["m5.large"]; !ok {
+		t.Error("expected the prices of the aws catalog")
+	}
+
+	err = crh.Execute("undo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crh.cctx.provider.Name() != "gcp" || crh.cctx.zone != "us-central1-a" {
+		t.Errorf("expected undo to restore provider gcp, got %s in %s", crh.cctx.provider.Name(), crh.cctx.zone)
+	}
+
+	err = crh.Execute("machines_provider openstack")
+	if err == nil {
+		t.Error("expected an unknown provider to fail")
+	}
+}
@@ -1,7 +1,11 @@
 package nodepacker
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
+	"io"
+	"os"
 	"sort"
 	"strings"
 
@@ -10,22 +14,89 @@ import (
 )
 
 type CommandContext struct {
-	pods     map[string]types.Resource
-	nodes    map[string]types.Resource
-	machines types.Machines
-	zone     string
+	replState
+	prices  types.Prices
+	history stateHistory
+
+	// name of the current scenario and the other scenarios by name
+	scenario  string
+	scenarios map[string]*scenario
+
+	// default output format of the commands with a -o flag
+	output string
+
+	// commands write their output to out and status messages and warnings to info
+	out  io.Writer
+	info io.Writer
+
+	// executes a script file, used by the source command
+	executeFile func(path string) error
+	// the commands by name, used by help
+	specs map[string]*commandSpec
+	// the configuration with the environment overrides and the path of the config file
+	config     *config
+	configPath string
+
+	// the statements entered in the REPL, nil if the history is not enabled
+	statements *statementHistory
+
+	// statements of the aliases by name and the number of nested alias expansions
+	aliases    map[string]string
+	aliasDepth int
+	// executes statements, used by aliases
+	execute func(statements string) error
+	// registers an alias as a command, an empty statement removes it
+	setAlias func(name, statement string)
+
+	// which arguments of the running command were quoted, see quotedArgs
+	quoted []bool
+}
+
+func (cctx *CommandContext) printf(format string, a ...interface{}) {
+	_, _ = fmt.Fprintf(cctx.out, format, a...)
+}
+
+func (cctx *CommandContext) println(a ...interface{}) {
+	_, _ = fmt.Fprintln(cctx.out, a...)
+}
+
+func (cctx *CommandContext) infof(format string, a ...interface{}) {
+	_, _ = fmt.Fprintf(cctx.info, format, a...)
+}
+
+func (cctx *CommandContext) infoln(a ...interface{}) {
+	_, _ = fmt.Fprintln(cctx.info, a...)
+}
+
+// quotedArgs returns which of args, the trailing arguments of the running command such as those
+// left by flag parsing, were quoted. It is nil if none were.
+func (cctx *CommandContext) quotedArgs(args []string) []bool {
+	if len(args) > len(cctx.quoted) {
+		return nil
+	}
+	return cctx.quoted[len(cctx.quoted)-len(args):]
 }
 
-type CommandFn func(*CommandContext, []string)
+// CommandFn runs a command. A command that fails returns an error and leaves the state unchanged.
+type CommandFn func(*CommandContext, []string) error
 
 type ArgsCompleter func(string, *CommandContext) []prompt.Suggest
 
+// FlagSetFn returns a flag set defining the flags a command parses its arguments with
+type FlagSetFn func() *flag.FlagSet
+
+// maximum nesting of scripts executed by source
+const maxScriptDepth = 10
+
 type CommandReplHandler struct {
-	buf           string
-	commands      map[string]CommandFn
-	cctx          *CommandContext
-	cc            *commandCompleter
-	argsCompleter map[string][]ArgsCompleter
+	buf         string
+	commands    map[string]CommandFn
+	cctx        *CommandContext
+	cc          *commandCompleter
+	specs       map[string]*commandSpec
+	scriptDepth int
+	// the builder adds and removes aliases at runtime
+	hb *handlerBuilder
 }
 
 type handlerBuilder struct {
@@ -33,21 +104,22 @@ type handlerBuilder struct {
 	allCommandsSuggestion    []prompt.Suggest
 	commandSuggestionsByName map[string]prompt.Suggest
 	sortedCommandNames       []string
-	argsCompleter            map[string][]ArgsCompleter
+	specs                    map[string]*commandSpec
 }
 
 func newBuilder() *handlerBuilder {
 	commands := make(map[string]CommandFn)
 	commandSuggestionsByName := make(map[string]prompt.Suggest)
-	argsCompleter := make(map[string][]ArgsCompleter)
 	return &handlerBuilder{
 		commands:                 commands,
 		commandSuggestionsByName: commandSuggestionsByName,
-		argsCompleter:            argsCompleter,
+		specs:                    make(map[string]*commandSpec),
 	}
 }
 
-func (hb *handlerBuilder) add(fn CommandFn, name, description string, acs ...ArgsCompleter) *handlerBuilder {
+// add adds a command, args are its positional arguments in order. The returned spec takes the rest
+// of the metadata of the command.
+func (hb *handlerBuilder) add(fn CommandFn, name, description string, args ...argument) *commandSpec {
 	hb.commands[name] = fn
 	hb.commandSuggestionsByName[name] = prompt.Suggest{
 		Text:        name,
@@ -58,74 +130,320 @@ func (hb *handlerBuilder) add(fn CommandFn, name, description string, acs ...Arg
 		Text:        name,
 		Description: description,
 	})
-	if acs != nil {
-		hb.argsCompleter[name] = acs
+	cs := &commandSpec{name: name, description: description, args: args}
+	hb.specs[name] = cs
+	return cs
+}
+
+// remove removes a command
+func (hb *handlerBuilder) remove(name string) {
+	delete(hb.commands, name)
+	delete(hb.commandSuggestionsByName, name)
+	delete(hb.specs, name)
+	for i, n := range hb.sortedCommandNames {
+		if n == name {
+			hb.sortedCommandNames = append(hb.sortedCommandNames[:i:i], hb.sortedCommandNames[i+1:]...)
+			break
+		}
+	}
+	for i, s := range hb.allCommandsSuggestion {
+		if s.Text == name {
+			hb.allCommandsSuggestion = append(hb.allCommandsSuggestion[:i:i], hb.allCommandsSuggestion[i+1:]...)
+			break
+		}
 	}
-	return hb
 }
 
-func (hb *handlerBuilder) build() *CommandReplHandler {
+// completer returns the completer of the command names
+func (hb *handlerBuilder) completer() *commandCompleter {
 	sort.Strings(hb.sortedCommandNames)
+	return &commandCompleter{
+		allCommandsSuggestion:    hb.allCommandsSuggestion,
+		sortedCommandNames:       hb.sortedCommandNames,
+		commandSuggestionsByName: hb.commandSuggestionsByName,
+	}
+}
+
+// addMutating adds a command that changes the state undo and redo step through
+func (hb *handlerBuilder) addMutating(fn CommandFn, name, description string, args ...argument) *commandSpec {
+	return hb.add(recordState(name, fn), name, description, args...)
+}
+
+func (hb *handlerBuilder) build(cfg *config, configPath string) *CommandReplHandler {
+	// the provider has been validated by loadConfig
+	provider, _ := lookupProvider(cfg.Provider)
+	machines, warning, err := loadProviderMachines(cfg.CacheDir, provider)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	} else if warning != "" {
+		fmt.Fprintln(os.Stderr, warning)
+	}
 
-	machines, err := readMachines()
+	prices, err := readPrices(cfg.CacheDir)
+	if err != nil {
+		prices = make(types.Prices)
+	}
+	prices, err = addCatalogPrices(prices, provider)
 	if err != nil {
-		fmt.Println("couldn't read machines from ~/.nodepacker/machines. please execute command machines_fetch")
+		fmt.Fprintln(os.Stderr, err)
 	}
 
-	return &CommandReplHandler{
+	crh := &CommandReplHandler{
 		commands: hb.commands,
-		cc: &commandCompleter{
-			allCommandsSuggestion:    hb.allCommandsSuggestion,
-			sortedCommandNames:       hb.sortedCommandNames,
-			commandSuggestionsByName: hb.commandSuggestionsByName,
+		cc:       hb.completer(),
+		cctx: &CommandContext{
+			replState:  replState{zone: cfg.Zone, machines: machines, provider: provider},
+			prices:     prices,
+			scenario:   defaultScenario,
+			output:     cfg.Output,
+			out:        os.Stdout,
+			info:       os.Stdout,
+			config:     cfg,
+			configPath: configPath,
 		},
-		cctx:          &CommandContext{zone: "us-central1-a", machines: machines},
-		argsCompleter: hb.argsCompleter,
+		specs: hb.specs,
+		hb:    hb,
 	}
+	crh.cctx.executeFile = crh.ExecuteFile
+	crh.cctx.execute = crh.Execute
+	crh.cctx.setAlias = crh.setAlias
+	crh.cctx.specs = hb.specs
+
+	crh.cctx.aliases = make(map[string]string)
+	for name, statement := range cfg.Aliases {
+		err := validAlias(crh.cctx, name, statement)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping alias %s: %v\n", name, err)
+			continue
+		}
+		crh.setAlias(name, statement)
+	}
+	return crh
 }
 
-func NewCommandReplHandler() *CommandReplHandler {
+// NewCommandReplHandler returns a handler configured by the config file at configPath or, if it
+// is empty, at the default location, see config
+func NewCommandReplHandler(configPath string) (*CommandReplHandler, error) {
+	if configPath == "" {
+		var err error
+		configPath, err = defaultConfigPath()
+		if err != nil {
+			return nil, err
+		}
+	}
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
 	hb := newBuilder()
 
-	hb.add(helpCommand, "help", "help [command]", nil)
-	hb.add(exitCommand, "exit", "exit nodepacker", nil)
+	hb.add(helpCommand, "help", "list the commands or show the usage of a command", commandArg).
+		usage("[command | filter]").
+		example("help nodes_pack", "help filter")
+	hb.add(exitCommand, "exit", "exit nodepacker")
+	hb.add(sourceCommand, "source", "execute the statements of a script file", pathArg).
+		usage("<file>").
+		example("source plan.np")
+	hb.add(outputCommand, "output", "get or set the default output format of the commands with a -o flag", outputArg).
+		usage("[table | json | yaml | csv]").
+		example("output json")
+	hb.add(historyCommand, "history", "list the statements entered in the REPL, !n executes statement n again").
+		usage("[n]").
+		example("history 20", "!12", "!!", "!-2")
+	hb.add(aliasCommand, "alias", "list, show or define aliases, $1, $2, ... and $@ in the statement are replaced with the arguments", aliasArg).
+		usage("[name [statement...]]").
+		example("alias pk 'manifests_read $1; nodes_pack -search -rank price'", "pk ./base", "alias ms machines_show -sort price", "ms cpu >= 8")
+	hb.add(unaliasCommand, "unalias", "remove aliases", aliasArg, aliasArg, aliasArg).
+		usage("<name>...")
+
+	hb.add(undoCommand, "undo", "undo the last state change")
+	hb.add(redoCommand, "redo", "redo the last undone state change")
+	hb.add(showStateHistoryCommand, "history_state", "show the state changes undo and redo step through")
+
+	hb.add(newScenarioCommand, "scenario_new", "create an empty scenario and switch to it").
+		usage("<name>")
+	hb.add(copyScenarioCommand, "scenario_copy", "copy the current scenario to a new one and switch to it").
+		usage("<name>").
+		example("scenario_copy bigger-nodes")
+	hb.add(switchScenarioCommand, "scenario_switch", "switch to another scenario", scenarioArg).
+		usage("<name>")
+	hb.add(listScenariosCommand, "scenario_list", "list scenarios")
+	hb.add(compareScenariosCommand, "scenario_compare", "compare the clusters of the given or all scenarios",
+		scenarioArg, scenarioArg, scenarioArg, scenarioArg).
+		usage("[scenario...]").
+		example("scenario_compare default bigger-nodes")
 
-	hb.add(fetchMachinesCommand, "machines_fetch", "fetch available machines from GCP", nil)
-	hb.add(getSetZoneCommand, "machines_zone", "get or set current zone", zoneComplete)
-	hb.add(showMachinesCommand, "machines_show", "show machines available in current zone", nil)
+	hb.add(saveSessionCommand, "session_save", "save session to a file", pathArg).
+		usage("<file>").
+		example("session_save plan.yaml")
+	hb.add(loadSessionCommand, "session_load", "load session from a file, replacing all scenarios", pathArg).
+		usage("<file>")
 
-	hb.add(addNodesCommand, "nodes_add", "add nodes to cluster", machineComplete)
-	hb.add(packCommand, "nodes_pack", "pack nodes", nil)
+	hb.addMutating(fetchMachinesCommand, "machines_fetch", "fetch available machines from the current provider")
+	hb.addMutating(getSetZoneCommand, "machines_zone", "get or set current zone", zoneArg).
+		usage("[zone]").
+		example("machines_zone us-east1-b")
+	hb.addMutating(providerCommand, "machines_provider", "get or set the cloud provider machines and prices come from",
+		valuesArg(providerNames()...)).
+		usage("[gcp|aws|azure]").
+		example("machines_provider aws")
+	hb.addMutating(importMachinesCommand, "machines_import", "import machine types from YAML, JSON or CSV catalog files", pathArg).
+		usage("<file>...").
+		example("machines_import machines.yaml", "machines_import machines.csv")
+	hb.add(showMachinesCommand, "machines_show", "show machines available in current zone").
+		usage("[flags] [filter]").
+		flags(new(showMachinesOptions).flagSet).
+		flagComplete("sort", valuesComplete(machineSortOrders...)).
+		flagComplete("o", outputComplete).
+		filtered(machineComplete).
+		example("machines_show -sort price cpu >= 8 & mem < 64", "machines_show name ~= 'n2-.*'",
+			"machines_show arch = 'arm64' & shared = false & net >= 10")
 
-	hb.add(readManifestsCommand, "manifests_read", "read manifests", pathComplete)
+	hb.add(loadPricesCommand, "prices_load", "load machine and disk prices from a price catalog file", pathArg).
+		usage("<file>...").
+		example("prices_load prices.yaml")
 
-	hb.add(showPodsCommand, "pods_show", "show pods", nil)
+	hb.addMutating(addNodesCommand, "nodes_add", "add nodes to cluster", machineArg).
+		usage("<machineType> [count] [key=value...]").
+		example("nodes_add n1-standard-16 3 pool=default")
+	hb.addMutating(removeNodesCommand, "nodes_remove", "remove nodes from cluster", nodeArg).
+		usage("<node>...")
+	hb.add(showNodesCommand, "nodes_show", "show nodes of cluster with free capacity").
+		usage("[flags]").
+		flags(new(showNodesOptions).flagSet).
+		flagComplete("o", outputComplete)
+	hb.addMutating(packCommand, "nodes_pack", "pack the pods onto nodes").
+		usage("[flags]").
+		flags(new(packOptions).flagSet).
+		flagComplete("o", outputComplete).
+		flagComplete("rank", valuesComplete(rankOrders()...)).
+		flagComplete("pricing", valuesComplete(types.PricingModels...)).
+		flagComplete("strategy", valuesComplete(packStrategies...)).
+		flagComplete("machine", machineComplete).
+		flagComplete("spot-machine", machineComplete).
+		example("nodes_pack", "nodes_pack -search -rank price -pricing 1y", "nodes_pack -spot-kind Deployment",
+			"nodes_pack -existing -no-grow")
 
-	return hb.build()
+	hb.addMutating(readManifestsCommand, "manifests_read", "read manifests", pathArg).
+		usage("<file or directory>...").
+		example("manifests_read ./base")
+
+	hb.add(showPodsCommand, "pods_show", "show pods").
+		usage("[flags] [filter]").
+		flags(new(showPodsOptions).flagSet).
+		flagComplete("o", outputComplete).
+		filtered(podComplete).
+		example("pods_show cpu > 2", "pods_show -o csv")
+	hb.addMutating(pinPodCommand, "pods_pin", "pin pod to node, nodes_pack keeps it there", podArg, nodeArg).
+		usage("<pod> <node>").
+		example("pods_pin pgsql-0 node-3")
+	hb.addMutating(movePodCommand, "pods_move", "move pod to node", podArg, nodeArg).
+		usage("<pod> <node>")
+	hb.addMutating(unpinPodCommand, "pods_unpin", "unpin pods", podArg).
+		usage("<pod>... | -all")
+
+	return hb.build(cfg, configPath), nil
 }
 
-func (crh *CommandReplHandler) ExecuteStatement(statement string) {
-	parts := strings.Fields(statement)
+// SetOutput sets the writers commands write their output and their status messages and warnings to,
+// by default both are stdout
+func (crh *CommandReplHandler) SetOutput(out, info io.Writer) {
+	crh.cctx.out = out
+	crh.cctx.info = info
+}
 
-	n := len(parts)
-	if n == 0 {
-		return
+// ExecuteStatement is the go-prompt executor, it executes a line of ;-separated statements and
+// prints the error of a failed statement
+func (crh *CommandReplHandler) ExecuteStatement(statement string) {
+	if sh := crh.cctx.statements; sh != nil && strings.TrimSpace(statement) != "" {
+		expanded, ok, err := sh.expand(statement)
+		if err != nil {
+			crh.cctx.println(err)
+			return
+		}
+		if ok {
+			statement = expanded
+			crh.cctx.println(statement)
+		}
+		err = sh.add(statement)
+		if err != nil {
+			crh.cctx.infoln("warning: failed to save the history in", sh.path+":", err)
+		}
 	}
 
-	if parts[n-1] == ";" {
-		parts = parts[0 : n-1]
-	} else {
-		parts[n-1] = strings.TrimSuffix(parts[n-1], ";")
+	err := crh.Execute(statement)
+	if err != nil {
+		crh.cctx.println(err)
 	}
+}
 
-	command := parts[0]
-	args := parts[1:]
+// Execute executes the ;-separated statements and returns an error if a statement failed.
+// The statements following a failed statement are not executed. Arguments can be quoted, see
+// splitStatements.
+func (crh *CommandReplHandler) Execute(statements string) error {
+	sts, err := splitStatements(statements)
+	if err != nil {
+		return &CommandError{Err: err}
+	}
+	for _, st := range sts {
+		err := crh.run(st.words[0], st.words[1:], st.quoted[1:])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// run runs a single command with its arguments, quoted tells which of them were quoted and is nil
+// if none were. Errors are returned as *CommandError.
+func (crh *CommandReplHandler) run(command string, args []string, quoted []bool) error {
 	fn, ok := crh.commands[command]
 	if !ok {
-		fmt.Println("unknown command " + command)
-		return
+		return &CommandError{Command: command, Args: args, Err: errorf(ErrUnknownCommand, "unknown command %s", command)}
+	}
+
+	outer := crh.cctx.quoted
+	crh.cctx.quoted = quoted
+	err := fn(crh.cctx, args)
+	crh.cctx.quoted = outer
+	if err == errHelp {
+		return nil
+	}
+	if err != nil {
+		return &CommandError{Command: command, Args: args, Err: err}
+	}
+	return nil
+}
+
+// ExecuteScript executes the statements read from r line by line, stopping at the first failed
+// statement.
+func (crh *CommandReplHandler) ExecuteScript(r io.Reader, name string) error {
+	if crh.scriptDepth >= maxScriptDepth {
+		return fmt.Errorf("%s: scripts nested more than %d levels deep", name, maxScriptDepth)
+	}
+	crh.scriptDepth++
+	defer func() { crh.scriptDepth-- }()
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		err := crh.Execute(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// ExecuteFile executes the statements of the script file, see ExecuteScript
+func (crh *CommandReplHandler) ExecuteFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	fn(crh.cctx, args)
+	return crh.ExecuteScript(f, path)
 }
@@ -0,0 +1,194 @@
+package nodepacker
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nodepacker/types"
+)
+
+// newTestHandler returns a handler with an empty config file and cache directory
+func newTestHandler(t *testing.T) *CommandReplHandler {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	err := ioutil.WriteFile(path, []byte("cacheDir: "+dir+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crh, err := NewCommandReplHandler(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crh
+}
+
+func TestCommandErrors(t *testing.T) {
+	crh := newTestHandler(t)
+	var out bytes.Buffer
+	crh.SetOutput(&out, &out)
+
+	pods := map[string]types.Resource{"web-0": {Name: "web-0", CPU: 500, Memory: 1000}}
+	crh.cctx.pods = pods
+
+	tests := []struct {
+		statement string
+		kind      error
+	}{
+		{"no_such_command", ErrUnknownCommand},
+		{"manifests_read", ErrInvalidArgs},
+		{"pods_show -o xml", ErrInvalidArgs},
+		{"pods_pin web-0 node-0", ErrNotFound},
+		{"nodes_pack -anchors-per-node 3 -anchor-fit 2", ErrInvalidArgs},
+		{"undo", ErrMissingState},
+	}
+	for _, test := range tests {
+		err := crh.Execute(test.statement)
+		var ce *CommandError
+		if !errors.As(err, &ce) {
+			t.Errorf("%s: expected a *CommandError, got %v", test.statement, err)
+			continue
+		}
+		if !errors.Is(err, test.kind) {
+			t.Errorf("%s: expected error kind %v, got %v", test.statement, test.kind, err)
+		}
+	}
+
+	err := crh.Execute("manifests_read /does/not/exist")
+	if err == nil {
+		t.Fatal("expected reading missing manifests to fail")
+	}
+	if len(crh.cctx.pods) != 1 {
+		t.Errorf("failed manifests_read changed the pods: %v", crh.cctx.pods)
+	}
+	if len(crh.cctx.history.undo) != 0 {
+		t.Errorf("failed commands were recorded for undo: %v", crh.cctx.history.undo)
+	}
+}
+
+func TestCommandOutput(t *testing.T) {
+	crh := newTestHandler(t)
+	var out, info bytes.Buffer
+	crh.SetOutput(&out, &info)
+	crh.cctx.pods = map[string]types.Resource{"web-0": {Name: "web-0", CPU: 500, Memory: 1000}}
+
+	err := crh.Execute("pods_show -o csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "name,workload,kind,cpuMillis,memoryMB,storageMB,node,pinnedTo\nweb-0,,,500,1000,0,,\n"
+	if out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+	if info.Len() != 0 {
+		t.Errorf("expected no status messages, got %q", info.String())
+	}
+}
+
+func TestHelp(t *testing.T) {
+	crh := newTestHandler(t)
+	var out bytes.Buffer
+	crh.SetOutput(&out, &out)
+
+	for name := range crh.specs {
+		out.Reset()
+		err := crh.Execute("help " + name)
+		if err != nil {
+			t.Errorf("help %s: %v", name, err)
+		}
+		if !bytes.HasPrefix(out.Bytes(), []byte("usage: "+name)) {
+			t.Errorf("help %s: expected usage, got %q", name, out.String())
+		}
+	}
+
+	out.Reset()
+	err := crh.Execute("help pods_move")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "completion:\n  argument 1: pods\n  argument 2: nodes of the cluster\n") {
+		t.Errorf("expected the arguments of pods_move to be described, got %q", out.String())
+	}
+	out.Reset()
+	err = crh.Execute("help machines_provider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "argument 1: one of "+strings.Join(providerNames(), ", ")) {
+		t.Errorf("expected the providers to be described, got %q", out.String())
+	}
+
+	err = crh.Execute("help no_such_command")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected help of unknown command to fail with ErrNotFound, got %v", err)
+	}
+}
+
+func TestExecuteScript(t *testing.T) {
+	crh, _ := newClusterTestHandler(t)
+
+	script := `# a comment
+nodes_add small; nodes_add small # two nodes
+
+nodes_remove node-5
+nodes_add small
+`
+	err := crh.ExecuteScript(strings.NewReader(script), "stdin")
+	if err == nil || err.Error() != "stdin:4: unknown node node-5" {
+		t.Errorf("expected the script to fail in line 4, got %v", err)
+	}
+	if len(crh.cctx.nodes) != 2 {
+		t.Errorf("expected the script to stop at the failed statement with 2 nodes, got %d", len(crh.cctx.nodes))
+	}
+
+	// -c executes ;-separated statements up to the first failed one
+	err = crh.Execute("nodes_remove node-0; no_such_command; nodes_remove node-1")
+	if !errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("expected ErrUnknownCommand, got %v", err)
+	}
+	if got := nodeNames(crh.cctx.nodes); len(got) != 1 || got[0] != "node-1" {
+		t.Errorf("expected node-1 to be left, got %v", got)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nodes.np")
+	err = ioutil.WriteFile(path, []byte("nodes_add small 2\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = crh.ExecuteFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested := filepath.Join(dir, "nested.np")
+	err = ioutil.WriteFile(nested, []byte("source "+path+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = crh.Execute("source " + nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crh.cctx.nodes) != 5 {
+		t.Errorf("expected 5 nodes after sourcing the scripts, got %d", len(crh.cctx.nodes))
+	}
+
+	self := filepath.Join(dir, "self.np")
+	err = ioutil.WriteFile(self, []byte("source "+self+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = crh.ExecuteFile(self)
+	if err == nil || !strings.Contains(err.Error(), "nested more than 10 levels deep") {
+		t.Errorf("expected a script sourcing itself to fail, got %v", err)
+	}
+	if crh.scriptDepth != 0 {
+		t.Errorf("expected the script depth to be reset, got %d", crh.scriptDepth)
+	}
+	if err := crh.ExecuteFile(filepath.Join(dir, "missing.np")); err == nil {
+		t.Error("expected a missing script to fail")
+	}
+}
@@ -0,0 +1,226 @@
+package nodepacker
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"nodepacker/types"
+)
+
+const defaultScenario = "default"
+
+// scenario is a named set of pods, zone, nodes and pins, each with its own undo history.
+// The machine catalog is shared by all scenarios.
+type scenario struct {
+	state   replState
+	history stateHistory
+}
+
+// scenarioNames returns the names of all scenarios, including the current one, sorted
+func (cctx *CommandContext) scenarioNames() []string {
+	names := []string{cctx.scenario}
+	for name := range cctx.scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// scenarioState returns the state of the named scenario
+func (cctx *CommandContext) scenarioState(name string) (*replState, bool) {
+	if name == cctx.scenario {
+		return &cctx.replState, true
+	}
+	s, ok := cctx.scenarios[name]
+	if !ok {
+		return nil, false
+	}
+	return &s.state, true
+}
+
+// switchScenario stores the current scenario and makes s the current scenario
+func (cctx *CommandContext) switchScenario(name string, s *scenario) {
+	if cctx.scenarios == nil {
+		cctx.scenarios = make(map[string]*scenario)
+	}
+	cctx.scenarios[cctx.scenario] = &scenario{state: cctx.replState, history: cctx.history}
+	delete(cctx.scenarios, name)
+
+	s.state.machines = cctx.machines
+	s.state.provider = cctx.provider
+	cctx.replState = s.state
+	cctx.history = s.history
+	cctx.scenario = name
+}
+
+func validScenarioName(cctx *CommandContext, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", argsErrorf("expected a scenario name")
+	}
+	if _, ok := cctx.scenarioState(args[0]); ok {
+		return "", argsErrorf("scenario already exists: %s", args[0])
+	}
+	return args[0], nil
+}
+
+// scenario_new <name>
+func newScenarioCommand(cctx *CommandContext, args []string) error {
+	name, err := validScenarioName(cctx, args)
+	if err != nil {
+		return err
+	}
+
+	cctx.switchScenario(name, &scenario{state: replState{zone: cctx.zone}})
+	cctx.println("switched to new scenario", name)
+	return nil
+}
+
+// scenario_copy <name> copies the current scenario
+func copyScenarioCommand(cctx *CommandContext, args []string) error {
+	name, err := validScenarioName(cctx, args)
+	if err != nil {
+		return err
+	}
+
+	from := cctx.scenario
+	cctx.switchScenario(name, &scenario{state: cctx.replState.clone()})
+	cctx.printf("copied scenario %s to %s and switched to it\n", from, name)
+	return nil
+}
+
+// scenario_switch <name>
+func switchScenarioCommand(cctx *CommandContext, args []string) error {
+	if len(args) != 1 {
+		return argsErrorf("expected a scenario name")
+	}
+	if args[0] == cctx.scenario {
+		cctx.println("already in scenario", args[0])
+		return nil
+	}
+	s, ok := cctx.scenarios[args[0]]
+	if !ok {
+		return notFoundErrorf("unknown scenario %s", args[0])
+	}
+
+	cctx.switchScenario(args[0], s)
+	cctx.printf("switched to scenario %s: %s\n", args[0], cctx.replState.summary())
+	return nil
+}
+
+func listScenariosCommand(cctx *CommandContext, args []string) error {
+	for _, name := range cctx.scenarioNames() {
+		s, _ := cctx.scenarioState(name)
+		marker := " "
+		if name == cctx.scenario {
+			marker = "*"
+		}
+		cctx.printf("%s %s: %s\n", marker, name, s.summary())
+	}
+	return nil
+}
+
+// scenarioStats summarizes the cluster of a scenario
+type scenarioStats struct {
+	nodes    int
+	machines string
+	capacity types.Resource
+	free     types.Resource
+	// monthly cost with on-demand prices for nodes and spot prices for nodes of the spot pool,
+	// negative if a price is missing
+	monthly float64
+}
+
+func statsOf(s *replState, zp types.ZonePrices) scenarioStats {
+	st := scenarioStats{nodes: len(s.nodes)}
+
+	counts := make(map[string]int)
+	hourly := 0.0
+	priced := true
+	for _, node := range s.nodes {
+		counts[node.machine.Name]++
+		st.capacity = types.AddResources(st.capacity, node.machine)
+		st.free = types.AddResources(st.free, node.free)
+
+		price, ok := zp.Machines[node.machine.Name]
+		if !ok {
+			priced = false
+			continue
+		}
+		if node.labels["pool"] == "spot" {
+			hourly += price.Spot
+		} else {
+			hourly += price.OnDemand
+		}
+	}
+
+	var ms []string
+	for name, n := range counts {
+		ms = append(ms, fmt.Sprintf("%dx %s", n, name))
+	}
+	sort.Strings(ms)
+	st.machines = strings.Join(ms, ", ")
+
+	st.monthly = -1
+	if priced && len(s.nodes) > 0 {
+		storage := types.SumResourceMap(s.pods).Storage
+		st.monthly = hourly*types.HoursPerMonth + zp.Disk*float64(storage)/1000.0
+	}
+	return st
+}
+
+func utilisation(capacity, free int64) string {
+	if capacity == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(capacity-free)/float64(capacity)*100)
+}
+
+// scenario_compare [name...] tabulates the clusters of the given or all scenarios side by side
+func compareScenariosCommand(cctx *CommandContext, args []string) error {
+	names := args
+	if len(names) == 0 {
+		names = cctx.scenarioNames()
+	}
+
+	var stats []scenarioStats
+	for _, name := range names {
+		s, ok := cctx.scenarioState(name)
+		if !ok {
+			return notFoundErrorf("unknown scenario %s", name)
+		}
+		stats = append(stats, statsOf(s, cctx.prices[s.zone]))
+	}
+
+	w := tabwriter.NewWriter(cctx.out, 0, 0, 3, ' ', tabwriter.AlignRight)
+	unit := cctx.config.Units
+
+	row := func(label string, value func(i int, st scenarioStats) string) {
+		_, _ = fmt.Fprintf(w, "%s\t", label)
+		for i, st := range stats {
+			_, _ = fmt.Fprintf(w, "%s\t", value(i, st))
+		}
+		_, _ = fmt.Fprintln(w)
+	}
+
+	row("", func(i int, _ scenarioStats) string { return names[i] })
+	row("zone", func(i int, _ scenarioStats) string {
+		s, _ := cctx.scenarioState(names[i])
+		return s.zone
+	})
+	row("nodes", func(_ int, st scenarioStats) string { return strconv.Itoa(st.nodes) })
+	row("machines", func(_ int, st scenarioStats) string { return st.machines })
+	row("vCPU", func(_ int, st scenarioStats) string { return types.FormatCPU(st.capacity.CPU) })
+	row("mem "+unit, func(_ int, st scenarioStats) string { return types.FormatMachineMemory(st.capacity.Memory, unit) })
+	row("CPU used", func(_ int, st scenarioStats) string { return utilisation(st.capacity.CPU, st.free.CPU) })
+	row("mem used", func(_ int, st scenarioStats) string { return utilisation(st.capacity.Memory, st.free.Memory) })
+	row("$/month", func(_ int, st scenarioStats) string {
+		if st.monthly < 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f", st.monthly)
+	})
+	return w.Flush()
+}
@@ -0,0 +1,132 @@
+package nodepacker
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"nodepacker/types"
+)
+
+func TestScenarios(t *testing.T) {
+	crh, out := newClusterTestHandler(t)
+
+	err := crh.Execute("nodes_add small 2; scenario_copy bigger; nodes_add small; scenario_new empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crh.cctx.scenario != "empty" || len(crh.cctx.pods) != 0 || len(crh.cctx.nodes) != 0 {
+		t.Errorf("expected the new scenario to be empty, got %s: %s", crh.cctx.scenario, crh.cctx.replState.summary())
+	}
+	if !reflect.DeepEqual(crh.cctx.machines, crh.cctx.scenarios[defaultScenario].state.machines) {
+		t.Error("expected the scenarios to share the machines")
+	}
+
+	err = crh.Execute("scenario_switch bigger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crh.cctx.nodes) != 3 || len(crh.cctx.pods) != 3 {
+		t.Errorf("expected 3 pods and 3 nodes in bigger, got %s", crh.cctx.replState.summary())
+	}
+	// each scenario has its own undo history
+	err = crh.Execute("undo; scenario_switch default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crh.cctx.nodes) != 2 {
+		t.Errorf("expected 2 nodes in default, got %d", len(crh.cctx.nodes))
+	}
+	if err := crh.Execute("undo; undo"); !errors.Is(err, ErrMissingState) {
+		t.Errorf("expected default to have one state change, got %v", err)
+	}
+
+	tests := []struct {
+		statement string
+		kind      error
+	}{
+		{"scenario_new", ErrInvalidArgs},
+		{"scenario_new bigger", ErrInvalidArgs},
+		{"scenario_copy default", ErrInvalidArgs},
+		{"scenario_switch missing", ErrNotFound},
+		{"scenario_compare default missing", ErrNotFound},
+	}
+	for _, test := range tests {
+		err := crh.Execute(test.statement)
+		if !errors.Is(err, test.kind) {
+			t.Errorf("%s: expected error kind %v, got %v", test.statement, test.kind, err)
+		}
+	}
+
+	out.Reset()
+	err = crh.Execute("scenario_list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"  bigger: zone " + crh.cctx.zone + ", 3 pods, 2 nodes, 0 pins",
+		"* default: zone " + crh.cctx.zone + ", 3 pods, 0 nodes, 0 pins",
+		"  empty: zone " + crh.cctx.zone + ", 0 pods, 0 nodes, 0 pins"}
+	if got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), out.String())
+	}
+}
+
+func TestCompareScenarios(t *testing.T) {
+	crh, out := newClusterTestHandler(t)
+	crh.cctx.prices = types.Prices{crh.cctx.zone: {Machines: map[string]types.Price{"small": {OnDemand: 0.1}}}}
+
+	err := crh.Execute("nodes_add small 2; scenario_copy three; nodes_add small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	err = crh.Execute("scenario_compare default three")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		fields := strings.Fields(line)
+		rows[fields[0]] = fields[1:]
+	}
+	if !reflect.DeepEqual(rows["nodes"], []string{"2", "3"}) {
+		t.Errorf("unexpected nodes row %v in\n%s", rows["nodes"], out.String())
+	}
+	if !reflect.DeepEqual(rows["$/month"], []string{"146.00", "219.00"}) {
+		t.Errorf("unexpected cost row %v in\n%s", rows["$/month"], out.String())
+	}
+}
+
+func TestSessionScenarios(t *testing.T) {
+	crh, _ := newClusterTestHandler(t)
+	path := filepath.Join(t.TempDir(), "session.yaml")
+
+	err := crh.Execute("nodes_add small; scenario_copy copy; nodes_add small; scenario_new other; session_save " + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, _ := newClusterTestHandler(t)
+	err = loaded.Execute("session_load " + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.cctx.scenario != "other" {
+		t.Errorf("expected the current scenario other, got %s", loaded.cctx.scenario)
+	}
+	names := loaded.cctx.scenarioNames()
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"copy", "default", "other"}) {
+		t.Errorf("unexpected scenarios %v", names)
+	}
+	for _, name := range names {
+		want, _ := crh.cctx.scenarioState(name)
+		got, _ := loaded.cctx.scenarioState(name)
+		if !reflect.DeepEqual(nodeNames(got.nodes), nodeNames(want.nodes)) || !reflect.DeepEqual(got.pods, want.pods) {
+			t.Errorf("%s: expected %s, got %s", name, want.summary(), got.summary())
+		}
+	}
+}
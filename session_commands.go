@@ -0,0 +1,198 @@
+package nodepacker
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"nodepacker/types"
+	"gopkg.in/yaml.v3"
+)
+
+// sessionVersion is the version of the session document written by session_save. session_load
+// reads documents up to this version.
+//
+// version 2 added scenarios
+const sessionVersion = 2
+
+// sessionDocument is the YAML representation of a REPL session. The current scenario is stored
+// at the top level, the other scenarios by name.
+type sessionDocument struct {
+	Version         int    `yaml:"version"`
+	Scenario        string `yaml:"scenario,omitempty"`
+	sessionScenario `yaml:",inline"`
+	Scenarios       map[string]sessionScenario `yaml:"scenarios,omitempty"`
+	Machines        types.Machines             `yaml:"machines,omitempty"`
+	Provider        string                     `yaml:"provider,omitempty"` // gcp if empty
+	Prices          types.Prices               `yaml:"prices,omitempty"`
+}
+
+type sessionScenario struct {
+	Zone  string                    `yaml:"zone"`
+	Pods  map[string]types.Resource `yaml:"pods,omitempty"`
+	Nodes []sessionNode             `yaml:"nodes,omitempty"`
+	Pins  map[string]string         `yaml:"pins,omitempty"`
+}
+
+type sessionNode struct {
+	Name    string            `yaml:"name"`
+	Machine types.Resource    `yaml:"machine"`
+	Labels  map[string]string `yaml:"labels,omitempty"`
+	Pods    []string          `yaml:"pods,omitempty"`
+	Free    types.Resource    `yaml:"free"`
+}
+
+func newSessionScenario(s *replState) sessionScenario {
+	ss := sessionScenario{
+		Zone: s.zone,
+		Pods: s.pods,
+		Pins: s.pins,
+	}
+	for _, node := range s.nodes {
+		ss.Nodes = append(ss.Nodes, sessionNode{
+			Name:    node.name,
+			Machine: node.machine,
+			Labels:  node.labels,
+			Pods:    node.pods,
+			Free:    node.free,
+		})
+	}
+	return ss
+}
+
+func newSessionDocument(cctx *CommandContext) *sessionDocument {
+	doc := &sessionDocument{
+		Version:         sessionVersion,
+		Scenario:        cctx.scenario,
+		sessionScenario: newSessionScenario(&cctx.replState),
+		Machines:        cctx.machines,
+		Provider:        cctx.provider.Name(),
+		Prices:          cctx.prices,
+	}
+	for name, sc := range cctx.scenarios {
+		if doc.Scenarios == nil {
+			doc.Scenarios = make(map[string]sessionScenario)
+		}
+		doc.Scenarios[name] = newSessionScenario(&sc.state)
+	}
+	return doc
+}
+
+func (ss *sessionScenario) state(machines types.Machines) replState {
+	s := replState{
+		pods:     ss.Pods,
+		machines: machines,
+		zone:     ss.Zone,
+		pins:     ss.Pins,
+	}
+	for _, sn := range ss.Nodes {
+		s.nodes = append(s.nodes, &clusterNode{
+			name:    sn.Name,
+			machine: sn.Machine,
+			labels:  sn.Labels,
+			pods:    sn.Pods,
+			free:    sn.Free,
+		})
+	}
+	return s
+}
+
+func saveSession(path string, doc *sessionDocument) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	bf := bufio.NewWriter(f)
+	defer bf.Flush()
+
+	e := yaml.NewEncoder(bf)
+	return e.Encode(doc)
+}
+
+func loadSession(path string) (*sessionDocument, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var doc sessionDocument
+
+	d := yaml.NewDecoder(bufio.NewReader(f))
+	err = d.Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode session %s: %v", path, err)
+	}
+	if doc.Version < 1 || doc.Version > sessionVersion {
+		return nil, fmt.Errorf("session %s has unsupported version %d, expected 1 to %d", path, doc.Version, sessionVersion)
+	}
+	return &doc, nil
+}
+
+func saveSessionCommand(cctx *CommandContext, args []string) error {
+	if len(args) != 1 {
+		return argsErrorf("expected a file name")
+	}
+
+	err := saveSession(args[0], newSessionDocument(cctx))
+	if err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	cctx.println("saved session to", args[0])
+	return nil
+}
+
+func loadSessionCommand(cctx *CommandContext, args []string) error {
+	if len(args) != 1 {
+		return argsErrorf("expected a file name")
+	}
+
+	doc, err := loadSession(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to load session: %w", err)
+	}
+	provider := providers[0]
+	if doc.Provider != "" {
+		provider, err = lookupProvider(doc.Provider)
+		if err != nil {
+			return fmt.Errorf("failed to load session: %w", err)
+		}
+	}
+
+	// sessions may leave out the machines, the current ones are kept then unless the session is of
+	// another provider
+	machines := doc.Machines
+	if machines == nil {
+		machines = cctx.machines
+		if provider.Name() != cctx.provider.Name() {
+			var warning string
+			machines, warning, err = loadProviderMachines(cctx.config.CacheDir, provider)
+			if err != nil {
+				return fmt.Errorf("failed to load session: %w", err)
+			}
+			if warning != "" {
+				cctx.infoln(warning)
+			}
+		}
+	}
+
+	cctx.replState = doc.state(machines)
+	cctx.provider = provider
+	cctx.history = stateHistory{}
+	cctx.scenario = doc.Scenario
+	if cctx.scenario == "" {
+		cctx.scenario = defaultScenario
+	}
+	cctx.scenarios = make(map[string]*scenario)
+	for name, ss := range doc.Scenarios {
+		cctx.scenarios[name] = &scenario{state: ss.state(machines)}
+		cctx.scenarios[name].state.provider = provider
+	}
+	if doc.Prices != nil {
+		cctx.prices = doc.Prices
+	}
+	cctx.printf("loaded session from %s, scenario %s: %s\n", args[0], cctx.scenario, cctx.replState.summary())
+	return nil
+}
@@ -0,0 +1,87 @@
+package nodepacker
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSessionRoundTrip(t *testing.T) {
+	crh, _ := newClusterTestHandler(t)
+	path := filepath.Join(t.TempDir(), "session.yaml")
+
+	err := crh.Execute("nodes_add small 2 pool=default; pods_pin a node-1; nodes_pack -existing; session_save " + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := crh.cctx.replState
+
+	loaded, _ := newClusterTestHandler(t)
+	loaded.cctx.pods = nil
+	err = loaded.Execute("session_load " + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := loaded.cctx.replState
+	if got.zone != saved.zone || !reflect.DeepEqual(got.pods, saved.pods) || !reflect.DeepEqual(got.pins, saved.pins) {
+		t.Errorf("expected zone %s, pods %v and pins %v, got %s, %v and %v", saved.zone, saved.pods, saved.pins,
+			got.zone, got.pods, got.pins)
+	}
+	if !reflect.DeepEqual(got.nodes, saved.nodes) {
+		t.Errorf("expected nodes %v, got %v", saved.nodes, got.nodes)
+	}
+	if !reflect.DeepEqual(got.machines, saved.machines) {
+		t.Errorf("expected machines %v, got %v", saved.machines, got.machines)
+	}
+	if len(loaded.cctx.history.undo) != 0 {
+		t.Errorf("expected session_load to reset the undo history, got %v", loaded.cctx.history.undo)
+	}
+
+	unsupported := filepath.Join(t.TempDir(), "unsupported.yaml")
+	err = ioutil.WriteFile(unsupported, []byte("version: 99\nzone: us-central1-a\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Execute("session_load " + unsupported); err == nil {
+		t.Error("expected a session of an unsupported version to fail")
+	}
+	if err := loaded.Execute("session_load"); !errors.Is(err, ErrInvalidArgs) {
+		t.Errorf("expected session_load without a file to fail with ErrInvalidArgs, got %v", err)
+	}
+	if !reflect.DeepEqual(loaded.cctx.nodes, saved.nodes) {
+		t.Errorf("failed session_load changed the nodes: %v", loaded.cctx.nodes)
+	}
+}
+
+func TestSessionWithoutMachines(t *testing.T) {
+	crh, _ := newClusterTestHandler(t)
+	machines := crh.cctx.machines
+	path := filepath.Join(t.TempDir(), "session.yaml")
+	err := ioutil.WriteFile(path, []byte(`version: 2
+zone: `+crh.cctx.zone+`
+pods:
+  a: {name: a, cpu: 1500, memory: 3000}
+scenarios:
+  other:
+    zone: `+crh.cctx.zone+`
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = crh.Execute("session_load " + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(crh.cctx.machines, machines) {
+		t.Errorf("expected the machines to be kept, got %v", crh.cctx.machines)
+	}
+	if !reflect.DeepEqual(crh.cctx.scenarios["other"].state.machines, machines) {
+		t.Errorf("expected scenario other to have the machines, got %v", crh.cctx.scenarios["other"].state.machines)
+	}
+	if len(crh.cctx.pods) != 1 {
+		t.Errorf("expected pod a, got %v", crh.cctx.pods)
+	}
+}
@@ -0,0 +1,142 @@
+package nodepacker
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"nodepacker/types"
+)
+
+// maximum number of states undo can go back
+const maxUndo = 100
+
+// replState is the part of the command context that undo and redo step through
+type replState struct {
+	pods     map[string]types.Resource
+	nodes    []*clusterNode
+	machines types.Machines
+	zone     string
+	pins     map[string]string // node names by pod name
+	// provider the machines come from
+	provider Provider
+}
+
+// clone returns a copy of the state that shares nothing mutable with s but the machines. Commands
+// replace the machines as a whole and never change them in place, so snapshots can share them.
+func (s *replState) clone() replState {
+	c := replState{machines: s.machines, zone: s.zone, provider: s.provider}
+
+	if s.pods != nil {
+		c.pods = make(map[string]types.Resource, len(s.pods))
+		for k, v := range s.pods {
+			c.pods[k] = v
+		}
+	}
+	if s.nodes != nil {
+		c.nodes = make([]*clusterNode, 0, len(s.nodes))
+	}
+	for _, node := range s.nodes {
+		cn := *node
+		cn.pods = append([]string(nil), node.pods...)
+		cn.labels = copyLabels(node.labels)
+		c.nodes = append(c.nodes, &cn)
+	}
+	if s.pins != nil {
+		c.pins = make(map[string]string, len(s.pins))
+		for k, v := range s.pins {
+			c.pins[k] = v
+		}
+	}
+	return c
+}
+
+func (s *replState) summary() string {
+	return fmt.Sprintf("zone %s, %d pods, %d nodes, %d pins", s.zone, len(s.pods), len(s.nodes), len(s.pins))
+}
+
+type stateSnapshot struct {
+	// statement that changed the state away from this snapshot
+	statement string
+	state     replState
+}
+
+// stateHistory holds the states before each state changing statement (undo) and the states
+// undone since (redo)
+type stateHistory struct {
+	undo []stateSnapshot
+	redo []stateSnapshot
+}
+
+// recordState wraps a state changing command so its previous state can be restored by undo. If the
+// command fails, the previous state is restored right away.
+func recordState(name string, fn CommandFn) CommandFn {
+	return func(cctx *CommandContext, args []string) error {
+		before := cctx.replState.clone()
+		err := fn(cctx, args)
+		if err != nil {
+			cctx.replState = before
+			return err
+		}
+		if reflect.DeepEqual(before, cctx.replState) {
+			return nil
+		}
+
+		h := &cctx.history
+		h.undo = append(h.undo, stateSnapshot{
+			statement: strings.Join(append([]string{name}, args...), " "),
+			state:     before,
+		})
+		if len(h.undo) > maxUndo {
+			h.undo = h.undo[len(h.undo)-maxUndo:]
+		}
+		h.redo = nil
+		return nil
+	}
+}
+
+func undoCommand(cctx *CommandContext, args []string) error {
+	h := &cctx.history
+	if len(h.undo) == 0 {
+		return missingErrorf("nothing to undo")
+	}
+
+	last := h.undo[len(h.undo)-1]
+	h.undo = h.undo[:len(h.undo)-1]
+	h.redo = append(h.redo, stateSnapshot{statement: last.statement, state: cctx.replState})
+	cctx.replState = last.state
+	cctx.printf("undid %q: %s\n", last.statement, cctx.replState.summary())
+	return nil
+}
+
+func redoCommand(cctx *CommandContext, args []string) error {
+	h := &cctx.history
+	if len(h.redo) == 0 {
+		return missingErrorf("nothing to redo")
+	}
+
+	next := h.redo[len(h.redo)-1]
+	h.redo = h.redo[:len(h.redo)-1]
+	h.undo = append(h.undo, stateSnapshot{statement: next.statement, state: cctx.replState})
+	cctx.replState = next.state
+	cctx.printf("redid %q: %s\n", next.statement, cctx.replState.summary())
+	return nil
+}
+
+func showStateHistoryCommand(cctx *CommandContext, args []string) error {
+	h := &cctx.history
+	if len(h.undo) == 0 && len(h.redo) == 0 {
+		cctx.println("no state changes recorded")
+		return nil
+	}
+
+	for i, s := range h.undo {
+		cctx.printf("%3d  %s\n     -> %q\n", i-len(h.undo), s.state.summary(), s.statement)
+	}
+	cctx.printf("  *  %s (current)\n", cctx.replState.summary())
+	for i := len(h.redo) - 1; i >= 0; i-- {
+		s := h.redo[i]
+		cctx.printf("     -> %q\n%3d  %s\n", s.statement, len(h.redo)-i, s.state.summary())
+	}
+	return nil
+}
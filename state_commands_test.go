@@ -0,0 +1,118 @@
+package nodepacker
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUndoRedo(t *testing.T) {
+	crh, out := newClusterTestHandler(t)
+
+	err := crh.Execute("nodes_add small; nodes_show; nodes_add small 2; pods_pin a node-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crh.cctx.history.undo) != 3 {
+		t.Fatalf("expected 3 recorded state changes, got %d", len(crh.cctx.history.undo))
+	}
+
+	out.Reset()
+	err = crh.Execute("history_state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`-> "nodes_add small"`, `-> "nodes_add small 2"`, `-> "pods_pin a node-2"`,
+		"zone " + crh.cctx.zone + ", 3 pods, 3 nodes, 1 pins (current)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in\n%s", want, out.String())
+		}
+	}
+
+	err = crh.Execute("undo; undo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crh.cctx.nodes) != 1 || len(crh.cctx.pins) != 0 {
+		t.Errorf("expected 1 node and no pins after undo, got %v and %v", nodeNames(crh.cctx.nodes), crh.cctx.pins)
+	}
+	err = crh.Execute("redo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crh.cctx.nodes) != 3 {
+		t.Errorf("expected 3 nodes after redo, got %v", nodeNames(crh.cctx.nodes))
+	}
+
+	// a new state change drops what was undone
+	err = crh.Execute("nodes_remove node-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crh.Execute("redo"); !errors.Is(err, ErrMissingState) {
+		t.Errorf("expected nothing to redo, got %v", err)
+	}
+	err = crh.Execute("undo; undo; undo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crh.cctx.nodes) != 0 {
+		t.Errorf("expected no nodes, got %v", nodeNames(crh.cctx.nodes))
+	}
+	if err := crh.Execute("undo"); !errors.Is(err, ErrMissingState) {
+		t.Errorf("expected nothing to undo, got %v", err)
+	}
+}
+
+func TestUndoLimit(t *testing.T) {
+	crh, _ := newClusterTestHandler(t)
+
+	for i := 0; i < maxUndo+5; i++ {
+		err := crh.Execute("nodes_add small")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := crh.cctx.history
+	if len(h.undo) != maxUndo {
+		t.Fatalf("expected %d recorded state changes, got %d", maxUndo, len(h.undo))
+	}
+	if n := len(h.undo[0].state.nodes); n != 5 {
+		t.Errorf("expected the oldest state to have 5 nodes, got %d", n)
+	}
+	// snapshots share the machines
+	if reflect.ValueOf(h.undo[0].state.machines).Pointer() != reflect.ValueOf(crh.cctx.machines).Pointer() {
+		t.Error("expected snapshots to share the machines")
+	}
+}
+
+func TestFailedCommandRestoresState(t *testing.T) {
+	crh, _ := newClusterTestHandler(t)
+
+	err := crh.Execute("nodes_add small 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail := recordState("fail", func(cctx *CommandContext, args []string) error {
+		cctx.nodes = cctx.nodes[:1]
+		cctx.pods = nil
+		return argsErrorf("failed")
+	})
+	err = fail(crh.cctx, nil)
+	if err == nil {
+		t.Fatal("expected the command to fail")
+	}
+	if len(crh.cctx.nodes) != 2 || len(crh.cctx.pods) != 3 {
+		t.Errorf("expected the state to be restored, got %v and %d pods", nodeNames(crh.cctx.nodes), len(crh.cctx.pods))
+	}
+
+	err = crh.Execute("nodes_remove node-0 node-9")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected an unknown node to fail, got %v", err)
+	}
+	if len(crh.cctx.nodes) != 2 || len(crh.cctx.history.undo) != 1 {
+		t.Errorf("expected the failed command to change nothing, got %v and %d state changes",
+			nodeNames(crh.cctx.nodes), len(crh.cctx.history.undo))
+	}
+}
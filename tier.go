@@ -0,0 +1,175 @@
+package nodepacker
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"nodepacker/filter"
+	"nodepacker/types"
+)
+
+// tier describes how one node pool of a plan is packed
+type tier struct {
+	anchor     *anchorSelector
+	anchorDesc string
+	perNode    int
+	fit        int
+	// fixed machine type, empty to choose one
+	machineType string
+	pricing     string
+	// rank order of all machine types in the zone, empty to choose a machine type by relativeCost
+	rank       string
+	nodePrefix string
+	strategy   packStrategy
+	// nodes to pack onto instead of starting a new cluster, and whether to add nodes to them
+	existing []*clusterNode
+	grow     bool
+	pins     map[string]string
+	// writer for messages about sizing the machines, nil to be quiet
+	info io.Writer
+}
+
+func (t *tier) printf(format string, args ...interface{}) {
+	if t.info != nil {
+		_, _ = fmt.Fprintf(t.info, format, args...)
+	}
+}
+
+// plan packs the pods onto nodes of this tier. With a rank order every machine type in the zone is
+// tried and the plans are returned best first, otherwise exactly one plan is returned.
+func (t *tier) plan(pods map[string]types.Resource, ms map[string]types.Resource, zp types.ZonePrices) ([]*packPlan, error) {
+	anchors, anchorR := selectAnchors(pods, t.anchor)
+	if len(anchors) == 0 {
+		anchorR = largestPod(pods)
+		if t.anchor != nil {
+			t.printf("no anchor pods matching %q, ", t.anchorDesc)
+		}
+		t.printf("sizing machines by largest pod %s\n", anchorR.String())
+	} else {
+		t.printf("replica count for anchor pods is %d\n", len(anchors))
+	}
+
+	var plans []*packPlan
+	switch {
+	case t.existing != nil:
+		// grow with the requested machine type or the one of the most recently added node
+		m := t.existing[len(t.existing)-1].machine
+		if t.machineType != "" {
+			var ok bool
+			m, ok = ms[t.machineType]
+			if !ok {
+				return nil, fmt.Errorf("unknown machine type %s", t.machineType)
+			}
+		}
+		plans = append(plans, packOnto(t.existing, t.grow, pods, anchors, t.perNode, t.pins, m, t.strategy))
+	case t.machineType != "":
+		m, ok := ms[t.machineType]
+		if !ok {
+			return nil, fmt.Errorf("unknown machine type %s", t.machineType)
+		}
+		plans = append(plans, packOnto(nil, true, pods, anchors, t.perNode, t.pins, m, t.strategy))
+	case t.rank != "":
+		plans = searchPlans(pods, anchors, t.perNode, t.pins, anchorR, ms, t.strategy)
+		if len(plans) == 0 {
+			return nil, fmt.Errorf("no machine type can hold %d pods of %s", t.perNode, anchorR.String())
+		}
+	default:
+		want := types.Resource{
+			Memory: anchorR.Memory * int64(t.fit),
+			CPU:    anchorR.CPU * int64(t.fit),
+		}
+		bestMachineType := chooseMachine(ms, t.strategy.headroom.needed(want))
+		if bestMachineType == "" {
+			return nil, fmt.Errorf("no machine type can hold %d pods of %s", t.fit, anchorR.String())
+		}
+		plans = append(plans, packOnto(nil, true, pods, anchors, t.perNode, t.pins, ms[bestMachineType], t.strategy))
+	}
+
+	for _, plan := range plans {
+		var err error
+		plan.cost, err = costOf(plan, pods, zp, t.pricing)
+		if err != nil {
+			return nil, err
+		}
+		if t.nodePrefix != "" {
+			for i, node := range plan.nodes {
+				node.name = fmt.Sprintf("%s-%d", t.nodePrefix, i)
+				node.labels = map[string]string{"pool": t.nodePrefix}
+			}
+		}
+	}
+
+	if t.rank != "" {
+		err := rankPlans(plans, t.rank)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return plans, nil
+}
+
+// spotClassifier decides which pods are stateless enough to run on spot (preemptible) nodes.
+// A pod goes to the spot pool if it matches any of the configured criteria.
+type spotClassifier struct {
+	kinds           map[string]bool
+	annotationKey   string
+	annotationValue string
+	filter          types.ResourceFilter
+}
+
+// newSpotClassifier returns nil if no criteria are given
+func newSpotClassifier(kinds, annotation, filterExpr string) (*spotClassifier, error) {
+	if kinds == "" && annotation == "" && filterExpr == "" {
+		return nil, nil
+	}
+
+	sc := &spotClassifier{kinds: make(map[string]bool)}
+	for _, kind := range strings.Split(kinds, ",") {
+		if kind = strings.TrimSpace(kind); kind != "" {
+			sc.kinds[kind] = true
+		}
+	}
+	if annotation != "" {
+		kv := strings.SplitN(annotation, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("expected <key>=<value>, got %s", annotation)
+		}
+		sc.annotationKey, sc.annotationValue = kv[0], kv[1]
+	}
+	if filterExpr != "" {
+		rf, err := filter.Create([]string{filterExpr}, nil)
+		if err != nil {
+			return nil, err
+		}
+		sc.filter = rf
+	}
+	return sc, nil
+}
+
+func (sc *spotClassifier) matches(pod types.Resource) bool {
+	if sc.kinds[pod.Kind] {
+		return true
+	}
+	if sc.annotationKey != "" {
+		if v, ok := pod.Annotations[sc.annotationKey]; ok && v == sc.annotationValue {
+			return true
+		}
+	}
+	return sc.filter != nil && sc.filter.Pass(pod)
+}
+
+// split divides the pods into those staying on on-demand nodes and those going to the spot pool
+func (sc *spotClassifier) split(pods map[string]types.Resource) (map[string]types.Resource, map[string]types.Resource) {
+	onDemand := make(map[string]types.Resource)
+	spot := make(map[string]types.Resource)
+
+	for k, v := range pods {
+		if sc.matches(v) {
+			spot[k] = v
+		} else {
+			onDemand[k] = v
+		}
+	}
+	return onDemand, spot
+}
@@ -0,0 +1,63 @@
+package nodepacker
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"nodepacker/types"
+)
+
+func TestSpotClassifier(t *testing.T) {
+	pods := map[string]types.Resource{
+		"frontend-0": {Name: "frontend-0", Kind: "Deployment"},
+		"pgsql-0":    {Name: "pgsql-0", Kind: "StatefulSet"},
+		"gitserver-0": {Name: "gitserver-0", Kind: "StatefulSet",
+			Annotations: map[string]string{"nodepacker/spot": "true"}},
+		"searcher-0": {Name: "searcher-0", Kind: "StatefulSet",
+			Annotations: map[string]string{"nodepacker/spot": "false"}},
+		"batch-0": {Name: "batch-0", Kind: "Job"},
+	}
+
+	tests := []struct {
+		kinds, annotation, filter string
+		spot                      []string
+	}{
+		{"Deployment", "", "", []string{"frontend-0"}},
+		{"Deployment, Job", "", "", []string{"batch-0", "frontend-0"}},
+		{"", "nodepacker/spot=true", "", []string{"gitserver-0"}},
+		{"", "", "name ~= 'batch-.*'", []string{"batch-0"}},
+		{"Deployment", "nodepacker/spot=true", "name = 'batch-0'", []string{"batch-0", "frontend-0", "gitserver-0"}},
+	}
+	for _, test := range tests {
+		sc, err := newSpotClassifier(test.kinds, test.annotation, test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		onDemand, spot := sc.split(pods)
+		var got []string
+		for name := range spot {
+			got = append(got, name)
+			if _, ok := onDemand[name]; ok {
+				t.Errorf("%s is in both pools", name)
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.spot) {
+			t.Errorf("%+v: expected spot pods %v, got %v", test, test.spot, got)
+		}
+		if len(onDemand)+len(spot) != len(pods) {
+			t.Errorf("%+v: expected every pod in one pool, got %v and %v", test, onDemand, spot)
+		}
+	}
+
+	if sc, err := newSpotClassifier("", "", ""); sc != nil || err != nil {
+		t.Errorf("expected no classifier without criteria, got %v, %v", sc, err)
+	}
+	if _, err := newSpotClassifier("", "nodepacker/spot", ""); err == nil {
+		t.Error("expected an annotation without value to fail")
+	}
+	if _, err := newSpotClassifier("", "", "cpu >"); err == nil {
+		t.Error("expected an invalid filter to fail")
+	}
+}
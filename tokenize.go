@@ -0,0 +1,144 @@
+package nodepacker
+
+import (
+	"strings"
+	"unicode"
+)
+
+// tokenizer splits a line into statements of words like a shell does:
+//
+//	words are separated by whitespace, statements by ;
+//	'...' quotes everything up to the next '
+//	"..." quotes everything up to the next unescaped ", \ escapes the next character
+//	\ outside of quotes escapes the next character
+//	# at the start of a word comments out the rest of the line
+//
+// A word with a quoted or escaped part is marked as quoted, filter expressions take it as a value.
+type tokenizer struct {
+	statements []statement
+	words      []string
+	quoted     []bool
+	word       strings.Builder
+	// whether a word has been started, a quoted empty string is a word too
+	inWord bool
+	// whether the word has a quoted or escaped part
+	wordQuoted bool
+	// the quote character of an open quote, 0 if none
+	quote rune
+	// whether the previous character was an unconsumed backslash
+	escape bool
+}
+
+// statement is a command and its arguments, quoted tells which of the words were quoted
+type statement struct {
+	words  []string
+	quoted []bool
+}
+
+func (t *tokenizer) endWord() {
+	if t.inWord {
+		t.words = append(t.words, t.word.String())
+		t.quoted = append(t.quoted, t.wordQuoted)
+		t.word.Reset()
+		t.inWord = false
+		t.wordQuoted = false
+	}
+}
+
+func (t *tokenizer) endStatement() {
+	t.endWord()
+	if len(t.words) > 0 {
+		t.statements = append(t.statements, statement{words: t.words, quoted: t.quoted})
+	}
+	t.words = nil
+	t.quoted = nil
+}
+
+func (t *tokenizer) scan(line string) {
+	for _, r := range line {
+		switch {
+		case t.escape:
+			t.word.WriteRune(r)
+			t.escape = false
+		case t.quote == '\'':
+			if r == '\'' {
+				t.quote = 0
+			} else {
+				t.word.WriteRune(r)
+			}
+		case t.quote == '"':
+			switch r {
+			case '"':
+				t.quote = 0
+			case '\\':
+				t.escape = true
+			default:
+				t.word.WriteRune(r)
+			}
+		case r == '\\':
+			t.inWord = true
+			t.wordQuoted = true
+			t.escape = true
+		case r == '\'' || r == '"':
+			t.inWord = true
+			t.wordQuoted = true
+			t.quote = r
+		case r == ';':
+			t.endStatement()
+		case r == '#' && !t.inWord:
+			return
+		case unicode.IsSpace(r):
+			t.endWord()
+		default:
+			t.inWord = true
+			t.word.WriteRune(r)
+		}
+	}
+}
+
+// splitStatements splits a line into statements of words, see tokenizer. Empty statements are
+// dropped.
+func splitStatements(line string) ([]statement, error) {
+	var t tokenizer
+	t.scan(line)
+	if t.quote != 0 {
+		return nil, argsErrorf("unterminated quote %c", t.quote)
+	}
+	if t.escape {
+		return nil, argsErrorf("unterminated escape at end of line")
+	}
+	t.endStatement()
+	return t.statements, nil
+}
+
+// lastStatement returns the last statement of an incomplete line as typed so far, the last word
+// is the one at the end of the line, it is empty if the line ends with whitespace outside of quotes
+func lastStatement(line string) statement {
+	var t tokenizer
+	t.scan(line)
+	t.inWord = true
+	t.endWord()
+	return statement{words: t.words, quoted: t.quoted}
+}
+
+// quoteWord quotes a word if needed so that splitStatements returns it unchanged
+func quoteWord(word string) string {
+	if word != "" && !strings.ContainsAny(word, " \t\r\n'\"\\;#") {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// quoteWords quotes words like quoteWord, the ones that were quoted are quoted in any case so that
+// splitStatements marks them as quoted again. quoted is nil if none were.
+func quoteWords(words []string, quoted []bool) []string {
+	res := make([]string, len(words))
+	for i, word := range words {
+		if quoted != nil && quoted[i] {
+			res[i] = "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+		} else {
+			res[i] = quoteWord(word)
+		}
+	}
+	return res
+}
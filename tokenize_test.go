@@ -0,0 +1,102 @@
+package nodepacker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		line string
+		want [][]string
+	}{
+		{"", nil},
+		{"pods_show", [][]string{{"pods_show"}}},
+		{"  manifests_read   ./base  ", [][]string{{"manifests_read", "./base"}}},
+		{"manifests_read ./base; nodes_pack", [][]string{{"manifests_read", "./base"}, {"nodes_pack"}}},
+		{"pods_show name ~= 'indexed search'", [][]string{{"pods_show", "name", "~=", "indexed search"}}},
+		{`manifests_read "my dir/base"`, [][]string{{"manifests_read", "my dir/base"}}},
+		{`manifests_read my\ dir`, [][]string{{"manifests_read", "my dir"}}},
+		{`session_save "a \"b\""`, [][]string{{"session_save", `a "b"`}}},
+		{"pods_show 'a;b' ; ; nodes_show", [][]string{{"pods_show", "a;b"}, {"nodes_show"}}},
+		{"nodes_pack # pack it", [][]string{{"nodes_pack"}}},
+		{"pods_pin a#1 ''", [][]string{{"pods_pin", "a#1", ""}}},
+	}
+
+	for _, test := range tests {
+		sts, err := splitStatements(test.line)
+		if err != nil {
+			t.Errorf("%q: %v", test.line, err)
+			continue
+		}
+		if got := statementWords(sts); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: expected %q, got %q", test.line, test.want, got)
+		}
+	}
+
+	for _, line := range []string{"pods_show 'web", `pods_show "web`, `pods_show web\`} {
+		if _, err := splitStatements(line); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
+
+func statementWords(sts []statement) [][]string {
+	var words [][]string
+	for _, st := range sts {
+		words = append(words, st.words)
+	}
+	return words
+}
+
+func TestSplitStatementsQuoted(t *testing.T) {
+	sts, err := splitStatements(`machines_show name = 'cpu' & mem > "8" | family = m\em; pods_show ''`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []statement{
+		{words: []string{"machines_show", "name", "=", "cpu", "&", "mem", ">", "8", "|", "family", "=", "mem"},
+			quoted: []bool{false, false, false, true, false, false, false, true, false, false, false, true}},
+		{words: []string{"pods_show", ""}, quoted: []bool{false, true}},
+	}
+	if !reflect.DeepEqual(sts, want) {
+		t.Errorf("expected %+v, got %+v", want, sts)
+	}
+}
+
+func TestLastStatement(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", []string{""}},
+		{"pods_", []string{"pods_"}},
+		{"pods_pin ", []string{"pods_pin", ""}},
+		{"nodes_show; pods_pin web", []string{"pods_pin", "web"}},
+		{"manifests_read 'my dir/b", []string{"manifests_read", "my dir/b"}},
+	}
+
+	for _, test := range tests {
+		got := lastStatement(test.line).words
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: expected %q, got %q", test.line, test.want, got)
+		}
+	}
+}
+
+func TestQuoteWord(t *testing.T) {
+	words := []string{"pods_show", "", "my dir", "it's", `a "b"`, "a;b", "#1", `c:\dir`}
+
+	var quoted []string
+	for _, w := range words {
+		quoted = append(quoted, quoteWord(w))
+	}
+	sts, err := splitStatements(strings.Join(quoted, " "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := statementWords(sts); !reflect.DeepEqual(got, [][]string{words}) {
+		t.Errorf("expected %q, got %q", words, got)
+	}
+}
@@ -0,0 +1,93 @@
+package types
+
+import "fmt"
+
+// HoursPerMonth is the number of hours GCP bills for a month
+const HoursPerMonth = 730
+
+// pricing models a machine can be bought with
+const (
+	PricingOnDemand = "ondemand"
+	PricingSpot     = "spot"
+	Pricing1Y       = "1y"
+	Pricing3Y       = "3y"
+)
+
+// PricingModels are all pricing models
+var PricingModels = []string{PricingOnDemand, PricingSpot, Pricing1Y, Pricing3Y}
+
+// Price of a machine type, unit is USD per hour
+type Price struct {
+	OnDemand float64 `yaml:"onDemand"`
+	Spot     float64 `yaml:"spot"`
+	Commit1Y float64 `yaml:"commit1y"`
+	Commit3Y float64 `yaml:"commit3y"`
+}
+
+// Hourly returns the hourly price for the given pricing model
+func (p Price) Hourly(model string) (float64, error) {
+	switch model {
+	case PricingOnDemand:
+		return p.OnDemand, nil
+	case PricingSpot:
+		return p.Spot, nil
+	case Pricing1Y:
+		return p.Commit1Y, nil
+	case Pricing3Y:
+		return p.Commit3Y, nil
+	}
+	return 0, fmt.Errorf("unknown pricing model %s, expected %s, %s, %s or %s", model,
+		PricingOnDemand, PricingSpot, Pricing1Y, Pricing3Y)
+}
+
+// ZonePrices are the prices of machine types and persistent disk in one zone
+type ZonePrices struct {
+	Machines map[string]Price `yaml:"machines"`
+	// unit is USD per GB and month
+	Disk float64 `yaml:"disk"`
+	// prices of custom machine types by family, for example n2
+	Custom map[string]CustomPrice `yaml:"custom,omitempty"`
+}
+
+// CustomPrice of a custom machine type family, CPU is USD per vCPU and hour, memory USD per GB and
+// hour
+type CustomPrice struct {
+	CPU    Price `yaml:"cpu"`
+	Memory Price `yaml:"memory"`
+}
+
+// Of returns the price of a custom machine type with the given vCPUs and GB of memory
+func (cp CustomPrice) Of(cpus, memoryGB float64) Price {
+	return Price{
+		OnDemand: cpus*cp.CPU.OnDemand + memoryGB*cp.Memory.OnDemand,
+		Spot:     cpus*cp.CPU.Spot + memoryGB*cp.Memory.Spot,
+		Commit1Y: cpus*cp.CPU.Commit1Y + memoryGB*cp.Memory.Commit1Y,
+		Commit3Y: cpus*cp.CPU.Commit3Y + memoryGB*cp.Memory.Commit3Y,
+	}
+}
+
+// prices by zone
+type Prices map[string]ZonePrices
+
+// Merge adds the zones and machine types of other to ps, replacing existing entries
+func (ps Prices) Merge(other Prices) {
+	for zone, zp := range other {
+		existing, ok := ps[zone]
+		if !ok || existing.Machines == nil {
+			existing.Machines = make(map[string]Price)
+		}
+		for name, p := range zp.Machines {
+			existing.Machines[name] = p
+		}
+		if zp.Disk != 0 {
+			existing.Disk = zp.Disk
+		}
+		for family, cp := range zp.Custom {
+			if existing.Custom == nil {
+				existing.Custom = make(map[string]CustomPrice)
+			}
+			existing.Custom[family] = cp
+		}
+		ps[zone] = existing
+	}
+}
@@ -3,29 +3,74 @@ package types
 import (
 	"fmt"
 	"sort"
-
-	"github.com/dustin/go-humanize"
 )
 
 type Resource struct {
 	Name string
+	// name of the Deployment or StatefulSet a pod belongs to
+	Workload string `yaml:",omitempty"`
+	// kind of the workload, Deployment or StatefulSet
+	Kind string `yaml:",omitempty"`
+	// pod template labels
+	Labels map[string]string `yaml:",omitempty"`
+	// pod template annotations
+	Annotations map[string]string `yaml:",omitempty"`
 
 	// unit is MB
 	Memory int64
 	// unit
 	CPU int64
-	// unit is GB
+	// unit is MB
 	Storage int64
+
+	// attributes of a machine type, empty for pods
+	Machine MachineInfo `yaml:",omitempty"`
+}
+
+// CPU architectures of machine types
+const (
+	ArchX86   = "x86"
+	ArchARM64 = "arm64"
+)
+
+// MachineInfo are the attributes of a machine type besides its resources
+type MachineInfo struct {
+	// family of the machine type, for example n2 or m5
+	Family string `yaml:"family,omitempty"`
+	// generation of the family, for example 2 for n2 and 5 for m5
+	Generation int `yaml:"generation,omitempty"`
+	// CPU architecture, ArchX86 or ArchARM64
+	Arch string `yaml:"arch,omitempty"`
+	// whether the machine type shares physical cores with other machines, like e2-micro
+	SharedCPU bool `yaml:"sharedCpu,omitempty"`
+	// whether local SSDs can be attached
+	LocalSSD bool `yaml:"localSsd,omitempty"`
+	// maximum number of persistent disks that can be attached
+	MaxPersistentDisks int `yaml:"maxPersistentDisks,omitempty"`
+	// maximum egress bandwidth, unit is Mbps
+	NetworkMbps int64 `yaml:"networkMbps,omitempty"`
+	// deprecation state, DEPRECATED, OBSOLETE or DELETED, empty if the machine type is current
+	Deprecated string `yaml:"deprecated,omitempty"`
+	// machine type recommended instead of a deprecated one
+	Replacement string `yaml:"replacement,omitempty"`
 }
 
 func (r Resource) String() string {
-	mem := humanize.Ftoa(float64(r.Memory) / 1000.0)
-	cpu := humanize.Ftoa(float64(r.CPU) / 1000.0)
+	return r.format(FormatMemory(r.Memory, UnitGB), UnitGB)
+}
+
+// FormatMachine is String for machine types and nodes with memory in the unit, see FormatMachineMemory
+func (r Resource) FormatMachine(unit string) string {
+	return r.format(FormatMachineMemory(r.Memory, unit), unit)
+}
+
+func (r Resource) format(mem, unit string) string {
+	cpu := FormatCPU(r.CPU)
 
 	if r.Name != "" {
-		return fmt.Sprintf("{%s, cpu: %s, mem: %s GB}", r.Name, cpu, mem)
+		return fmt.Sprintf("{%s, cpu: %s, mem: %s %s}", r.Name, cpu, mem, unit)
 	} else {
-		return fmt.Sprintf("{cpu: %s, mem: %s GB}", cpu, mem)
+		return fmt.Sprintf("{cpu: %s, mem: %s %s}", cpu, mem, unit)
 	}
 }
 
@@ -98,24 +143,24 @@ func (s *resourceSorter) Less(i, j int) bool {
 	return s.by(s.vals[s.order[i]], s.vals[s.order[j]])
 }
 
+// SortResources returns the keys of resources sorted by the comparator. Resources the comparator
+// considers equal are ordered by key, so the result doesn't depend on map iteration order.
 func SortResources(resources map[string]Resource, by ResourceComparator) []string {
 	keys := make([]string, 0, len(resources))
 	for key := range resources {
 		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 	rs := &resourceSorter{
 		vals:  resources,
 		by:    by,
 		order: keys,
 	}
 
-	sort.Sort(rs)
+	sort.Stable(rs)
 	return rs.order
 }
 
 func HumanReadableMemCPU(r Resource) string {
-	mem := float64(r.Memory) / 1000.0
-	cpu := float64(r.CPU) / 1000.0
-
-	return fmt.Sprintf("CPU %s, Mem %s", humanize.Ftoa(cpu), humanize.Ftoa(mem))
+	return fmt.Sprintf("CPU %s, Mem %s %s", FormatCPU(r.CPU), FormatMemory(r.Memory, UnitGB), UnitGB)
 }
@@ -0,0 +1,53 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// units memory is shown in, GB are 1000 MB and GiB 1024 MiB
+const (
+	UnitGB  = "GB"
+	UnitGiB = "GiB"
+)
+
+// MemoryUnits are the units memory can be shown in
+var MemoryUnits = []string{UnitGB, UnitGiB}
+
+// ParseMemoryUnit returns the memory unit named by unit, ignoring case
+func ParseMemoryUnit(unit string) (string, error) {
+	for _, u := range MemoryUnits {
+		if strings.EqualFold(unit, u) {
+			return u, nil
+		}
+	}
+	return "", fmt.Errorf("unknown unit %s, expected %s", unit, strings.Join(MemoryUnits, " or "))
+}
+
+// memory is stored in MB of two sizes: the machine catalogs count a GiB as 1000 MB while pods
+// count 10^6 bytes as a MB. Both are converted to the unit they are shown in.
+
+// FormatMemory formats memory of pods given in MB as a number in the unit, without the unit
+func FormatMemory(mb int64, unit string) string {
+	if unit == UnitGiB {
+		return humanize.Ftoa(math.Round(float64(mb)*1000*1000/(1<<30)*100) / 100)
+	}
+	return humanize.Ftoa(float64(mb) / 1000.0)
+}
+
+// FormatMachineMemory formats memory of machine types and nodes given in MB as a number in the
+// unit, without the unit. The memory of a node left to pods counts as machine memory.
+func FormatMachineMemory(mb int64, unit string) string {
+	if unit == UnitGiB {
+		return humanize.Ftoa(float64(mb) / 1000.0)
+	}
+	return humanize.Ftoa(math.Round(float64(mb)/1000*(1<<30)/(1000*1000*1000)*100) / 100)
+}
+
+// FormatCPU formats CPU given in millicores as vCPU
+func FormatCPU(millis int64) string {
+	return humanize.Ftoa(float64(millis) / 1000.0)
+}